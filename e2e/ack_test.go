@@ -0,0 +1,148 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	ackcore "github.com/aws-controllers-k8s/runtime/apis/core/v1alpha1"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+)
+
+// The helpers in this file work with any ACK custom resource, either a typed object of an ACK controller api package
+// or an unstructured object (see ackObject), by reading the common ACK status fields.
+
+const ackConditionTimeout = 3 * time.Minute // controller takes a while on initial start even after reporting available
+
+var accountIDRegex = regexp.MustCompile(`^[0-9]{12}$`)
+
+// ackStatus holds the status fields common to all ACK resources
+type ackStatus struct {
+	Conditions          []ackcore.Condition       `json:"conditions"`
+	ACKResourceMetadata *ackcore.ResourceMetadata `json:"ackResourceMetadata"`
+}
+
+// ackObject returns an unstructured ACK resource, e.g. to wait for resources of controllers without a Go api package.
+// The kind is not registered in a scheme.
+func ackObject(gvk schema.GroupVersionKind, name, namespace string) *unstructured.Unstructured {
+	var u unstructured.Unstructured
+	u.SetGroupVersionKind(gvk)
+	u.SetName(name)
+	u.SetNamespace(namespace)
+	return &u
+}
+
+// ackStatusOf returns the ACK status of obj
+func ackStatusOf(obj k8s.Object) (ackStatus, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return ackStatus{}, fmt.Errorf("convert to unstructured: %w", err)
+	}
+
+	var status ackStatus
+	raw, ok := u["status"].(map[string]any)
+	if !ok {
+		return status, nil
+	}
+
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &status); err != nil {
+		return ackStatus{}, fmt.Errorf("convert status: %w", err)
+	}
+
+	return status, nil
+}
+
+// ackConditionMatch returns a match function for conditions.ResourceMatch which is true if the ACK resource has a
+// condition of the given type and status. A non-empty message must be contained in the condition message.
+func ackConditionMatch(condType ackcore.ConditionType, status corev1.ConditionStatus, message string) func(k8s.Object) bool {
+	return func(obj k8s.Object) bool {
+		s, err := ackStatusOf(obj)
+		if err != nil {
+			klog.Errorf("could not read ack status of %q: %v", obj.GetName(), err)
+			return false
+		}
+
+		for _, cond := range s.Conditions {
+			if cond.Type != condType || cond.Status != status {
+				continue
+			}
+
+			if message == "" || (cond.Message != nil && strings.Contains(*cond.Message, message)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// assertACKCondition waits until the resource has a condition of the given type with status true whose message
+// contains message
+func assertACKCondition(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object, condType ackcore.ConditionType, message string) {
+	t.Helper()
+
+	klog.Infof("waiting for %q in namespace %q to report condition %q with message %q", obj.GetName(), obj.GetNamespace(), condType, message)
+	match := conditions.New(r).ResourceMatch(obj, ackConditionMatch(condType, corev1.ConditionTrue, message))
+	ctx, cancel := context.WithTimeout(ctx, ackConditionTimeout)
+	defer cancel()
+	err := wait.For(match, wait.WithContext(ctx))
+	assert.NilError(t, err, "condition %q not reported", condType)
+}
+
+func assertSynced(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object) {
+	t.Helper()
+	assertACKCondition(ctx, t, r, obj, ackcore.ConditionTypeResourceSynced, "")
+}
+
+func assertTerminal(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object, message string) {
+	t.Helper()
+	assertACKCondition(ctx, t, r, obj, ackcore.ConditionTypeTerminal, message)
+}
+
+func assertRecoverable(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object, message string) {
+	t.Helper()
+	assertACKCondition(ctx, t, r, obj, ackcore.ConditionTypeRecoverable, message)
+}
+
+// assertLateInitialized waits until the controller set the defaults of late initialized fields. Only resources with
+// late initialized fields report this condition.
+func assertLateInitialized(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object) {
+	t.Helper()
+	assertACKCondition(ctx, t, r, obj, ackcore.ConditionTypeLateInitialized, "")
+}
+
+// assertACKMetadata asserts the resource has an arn in the given region which is owned by the account in
+// ownerAccountID and returns the metadata. obj must be up to date, e.g. after assertSynced.
+func assertACKMetadata(t *testing.T, obj k8s.Object, region string) ackcore.ResourceMetadata {
+	t.Helper()
+
+	s, err := ackStatusOf(obj)
+	assert.NilError(t, err)
+
+	md := s.ACKResourceMetadata
+	assert.Assert(t, md != nil, "%q is missing status.ackResourceMetadata", obj.GetName())
+	assert.Assert(t, md.ARN != nil && *md.ARN != "", "%q is missing arn", obj.GetName())
+	assert.Assert(t, md.OwnerAccountID != nil && accountIDRegex.MatchString(string(*md.OwnerAccountID)),
+		"%q has invalid owner account id", obj.GetName())
+	assert.Assert(t, md.Region != nil, "%q is missing region", obj.GetName())
+	assert.Equal(t, string(*md.Region), region)
+
+	// e.g. arn:aws:events:us-east-1:000000000000:event-bus/name
+	parts := strings.SplitN(string(*md.ARN), ":", 6)
+	assert.Equal(t, len(parts), 6, "invalid arn %q", *md.ARN)
+	assert.Equal(t, parts[3], region, "arn region")
+	assert.Equal(t, parts[4], string(*md.OwnerAccountID), "arn account id")
+
+	return *md
+}
@@ -0,0 +1,262 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+	"sigs.k8s.io/yaml"
+)
+
+// artifactKinds are the resources dumped as yaml. Secrets are skipped, they may contain aws credentials.
+var artifactKinds = []schema.GroupVersionKind{
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Version: "v1", Kind: "Pod"},
+	{Version: "v1", Kind: "Service"},
+	{Version: "v1", Kind: "Endpoints"},
+	{Version: "v1", Kind: "ConfigMap"},
+	{Version: "v1", Kind: "PersistentVolumeClaim"},
+	{Group: "eventbridge.services.k8s.aws", Version: "v1alpha1", Kind: "EventBus"},
+	{Group: "eventbridge.services.k8s.aws", Version: "v1alpha1", Kind: "Rule"},
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+type artifactsCtxKey string
+
+// artifactsKey marks the artifacts of the current feature as collected
+const artifactsKey = artifactsCtxKey("artifactsCollected")
+
+// collectFailedArtifacts returns a teardown step which collects artifacts if the feature failed, e.g. before
+// uninstalling a helm release. finishFeature and AfterEachFeature do not collect them again.
+func collectFailedArtifacts() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		return collectArtifactsOnFailure(ctx, t, cfg, path.Base(t.Name()), t.Failed())
+	}
+}
+
+// collectArtifactsOnFailure collects the artifacts of the feature namespace into ArtifactsDir once if the feature
+// failed or ArtifactsAlways is set. Errors are logged and do not fail the test.
+func collectArtifactsOnFailure(ctx context.Context, t *testing.T, cfg *envconf.Config, feature string, failed bool) context.Context {
+	c := getConfigFromContext(ctx)
+	if c.ArtifactsDir == "" || !(c.ArtifactsAlways || failed) {
+		return ctx
+	}
+
+	if collected, _ := ctx.Value(artifactsKey).(bool); collected {
+		return ctx
+	}
+
+	ns := getTestNamespaceFromContext(ctx, t)
+	if err := collectArtifacts(ctx, cfg, c.ArtifactsDir, ns, feature); err != nil {
+		klog.Errorf("could not collect all artifacts of feature %q: %v", feature, err)
+	}
+	return context.WithValue(ctx, artifactsKey, true)
+}
+
+// collectArtifacts dumps pod logs, events, resources and helm releases of the feature namespace into a directory
+// named after the feature and namespace below dir. Feature names and subtest names map to the same directory.
+// Collection continues on errors, which are returned joined.
+func collectArtifacts(ctx context.Context, cfg *envconf.Config, dir, namespace, feature string) error {
+	name := unsafeFileChars.ReplaceAllString(strings.ToLower(feature), "-") + "_" + namespace
+	dir = filepath.Join(dir, name)
+
+	klog.Infof("collecting artifacts of feature %q in namespace %q to %q", feature, namespace, dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create artifacts directory: %w", err)
+	}
+
+	return errors.Join(
+		collectPodLogs(ctx, cfg, dir, namespace),
+		collectEvents(ctx, cfg, dir, namespace),
+		collectResources(ctx, cfg, dir, namespace),
+		collectHelmReleases(ctx, cfg, dir, namespace),
+	)
+}
+
+// collectPodLogs writes the logs of every container to pods/<pod>/<container>.log, and the logs of the previous
+// instance of restarted containers to <container>.previous.log
+func collectPodLogs(ctx context.Context, cfg *envconf.Config, dir, namespace string) error {
+	var pods corev1.PodList
+	if err := cfg.Client().Resources(namespace).List(ctx, &pods); err != nil {
+		return fmt.Errorf("list pods: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(cfg.Client().RESTConfig())
+	if err != nil {
+		return fmt.Errorf("create kubernetes client: %w", err)
+	}
+
+	var errs []error
+	for _, pod := range pods.Items {
+		podDir := filepath.Join(dir, "pods", pod.Name)
+		if err = os.MkdirAll(podDir, 0o755); err != nil {
+			errs = append(errs, fmt.Errorf("create pod directory: %w", err))
+			continue
+		}
+
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			previous := []bool{false}
+			if status.RestartCount > 0 {
+				previous = append(previous, true)
+			}
+
+			for _, p := range previous {
+				file := status.Name + ".log"
+				if p {
+					file = status.Name + ".previous.log"
+				}
+
+				opts := corev1.PodLogOptions{Container: status.Name, Previous: p}
+				logs, err := client.CoreV1().Pods(namespace).GetLogs(pod.Name, &opts).DoRaw(ctx)
+				if err != nil {
+					// containers which never started have no logs
+					logs = []byte(fmt.Sprintf("could not get logs: %v\n", err))
+				}
+
+				if err = os.WriteFile(filepath.Join(podDir, file), logs, 0o644); err != nil {
+					errs = append(errs, fmt.Errorf("write logs of pod %q: %w", pod.Name, err))
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectEvents writes the events of the namespace ordered by time to events.txt
+func collectEvents(ctx context.Context, cfg *envconf.Config, dir, namespace string) error {
+	var events corev1.EventList
+	if err := cfg.Client().Resources(namespace).List(ctx, &events); err != nil {
+		return fmt.Errorf("list events: %w", err)
+	}
+
+	sort.SliceStable(events.Items, func(i, j int) bool {
+		return eventTime(events.Items[i]).Before(eventTime(events.Items[j]))
+	})
+
+	var b strings.Builder
+	for _, e := range events.Items {
+		count := e.Count
+		if count == 0 {
+			count = 1
+		}
+
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s/%s\t(x%d)\t%s\n",
+			eventTime(e).UTC().Format("2006-01-02T15:04:05Z"),
+			e.Type,
+			e.Reason,
+			e.InvolvedObject.Kind,
+			e.InvolvedObject.Name,
+			count,
+			strings.TrimSpace(e.Message),
+		)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "events.txt"), []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("write events: %w", err)
+	}
+	return nil
+}
+
+func eventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+// collectResources writes the resources of every kind in artifactKinds to <kind>.yaml. Kinds which are not
+// installed in the cluster, e.g. ack custom resources, are skipped.
+func collectResources(ctx context.Context, cfg *envconf.Config, dir, namespace string) error {
+	var errs []error
+	for _, gvk := range artifactKinds {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		if err := cfg.Client().Resources(namespace).List(ctx, &list); err != nil {
+			if !meta.IsNoMatchError(err) {
+				errs = append(errs, fmt.Errorf("list %s: %w", gvk.Kind, err))
+			}
+			continue
+		}
+
+		if len(list.Items) == 0 {
+			continue
+		}
+
+		for _, item := range list.Items {
+			unstructured.RemoveNestedField(item.Object, "metadata", "managedFields")
+		}
+
+		out, err := yaml.Marshal(list.UnstructuredContent())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("marshal %s: %w", gvk.Kind, err))
+			continue
+		}
+
+		file := filepath.Join(dir, strings.ToLower(gvk.Kind)+".yaml")
+		if err = os.WriteFile(file, out, 0o644); err != nil {
+			errs = append(errs, fmt.Errorf("write %s: %w", gvk.Kind, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectHelmReleases writes the status of every helm release in the namespace to helm-<release>.txt
+func collectHelmReleases(ctx context.Context, cfg *envconf.Config, dir, namespace string) error {
+	helm := func(args ...string) ([]byte, error) {
+		args = append(args, "--namespace", namespace, "--kubeconfig", cfg.KubeconfigFile())
+		out, err := exec.CommandContext(ctx, "helm", args...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("helm %s: %w: %s", args[0], err, out)
+		}
+		return out, nil
+	}
+
+	out, err := helm("list", "--all", "--short")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, release := range strings.Fields(string(out)) {
+		status, err := helm("status", release, "--show-resources")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err = os.WriteFile(filepath.Join(dir, "helm-"+release+".txt"), status, 0o644); err != nil {
+			errs = append(errs, fmt.Errorf("write helm release %q: %w", release, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,164 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+)
+
+const defaultProfile = "default"
+
+type awsConfigCtxKey string
+
+// awsConfigKey stores the aws configuration with the resolved credentials of the eventbridge feature
+const awsConfigKey = awsConfigCtxKey("awsConfig")
+
+// awsConfig configures the aws credentials used by the test suite and the controller. Credentials are read from an
+// existing Kubernetes secret if CredentialsSecret is set, otherwise they are resolved with the aws sdk default chain,
+// i.e. environment variables (session token is optional), shared config profiles (AWS_PROFILE) or web identity token
+// files (AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN), e.g. with IRSA. The credentials are copied into a secret for
+// the controller which is not refreshed, so temporary credentials must outlive the test run.
+type awsConfig struct {
+	Region string `envconfig:"AWS_DEFAULT_REGION" required:"true"`
+	// CredentialsSecret references an existing secret as <namespace>/<name> with a shared credentials file
+	CredentialsSecret        string `envconfig:"AWS_CREDENTIALS_SECRET"`
+	CredentialsSecretKey     string `envconfig:"AWS_CREDENTIALS_SECRET_KEY" default:"credentials"`
+	CredentialsSecretProfile string `envconfig:"AWS_CREDENTIALS_SECRET_PROFILE" default:"default"`
+
+	// resolved credentials
+	AccessKey    string `ignored:"true"`
+	SecretKey    string `ignored:"true"`
+	SessionToken string `ignored:"true"`
+
+	// provider refreshes temporary credentials of the default chain for the sdk clients of the test suite
+	provider *credentials.Credentials
+}
+
+// credentials returns the resolved credentials for the sdk clients of the test suite
+func (c awsConfig) credentials() *credentials.Credentials {
+	if c.provider != nil {
+		return c.provider
+	}
+	return credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.SessionToken)
+}
+
+// secretProfile returns the profile in the credentials file of the controller secret
+func (c awsConfig) secretProfile() string {
+	if c.CredentialsSecret != "" {
+		return c.CredentialsSecretProfile
+	}
+	return defaultProfile
+}
+
+// resolveAWSCredentials resolves the credentials configured in c, stores them in c and returns the shared credentials
+// file for the controller secret
+func resolveAWSCredentials(ctx context.Context, cfg *envconf.Config, c *awsConfig) ([]byte, error) {
+	var (
+		data  []byte
+		creds *credentials.Credentials
+		err   error
+	)
+
+	if c.CredentialsSecret != "" {
+		data, err = secretCredentials(ctx, cfg, c.CredentialsSecret, c.CredentialsSecretKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// the shared credentials provider only reads from files
+		dir, err := os.MkdirTemp("", "e2e-aws-credentials")
+		if err != nil {
+			return nil, fmt.Errorf("create credentials directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		file := filepath.Join(dir, "credentials")
+		if err = os.WriteFile(file, data, 0o600); err != nil {
+			return nil, fmt.Errorf("write credentials file: %w", err)
+		}
+
+		klog.Infof("using aws credentials from secret %q with profile %q", c.CredentialsSecret, c.CredentialsSecretProfile)
+		creds = credentials.NewSharedCredentials(file, c.CredentialsSecretProfile)
+	} else {
+		s, err := session.NewSessionWithOptions(session.Options{
+			Config:            aws.Config{Region: aws.String(c.Region)},
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create aws session: %w", err)
+		}
+		creds = s.Config.Credentials
+		c.provider = creds
+	}
+
+	v, err := creds.GetWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve aws credentials: %w", err)
+	}
+	klog.Infof("resolved aws credentials with provider %q", v.ProviderName)
+
+	// the secret of the controller is not refreshed
+	if expires, err := creds.ExpiresAt(); err == nil {
+		klog.Infof("aws credentials of provider %q expire at %s, the controller uses them until then", v.ProviderName, expires)
+	}
+
+	c.AccessKey = v.AccessKeyID
+	c.SecretKey = v.SecretAccessKey
+	c.SessionToken = v.SessionToken
+
+	if data == nil {
+		data = awsCredentials(c.AccessKey, c.SecretKey, c.SessionToken)
+	}
+	return data, nil
+}
+
+// secretCredentials returns the credentials file stored under key in the secret referenced as <namespace>/<name>
+func secretCredentials(ctx context.Context, cfg *envconf.Config, ref, key string) ([]byte, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid secret reference %q: must be <namespace>/<name>", ref)
+	}
+
+	var secret corev1.Secret
+	if err := cfg.Client().Resources().Get(ctx, name, namespace, &secret); err != nil {
+		return nil, fmt.Errorf("get credentials secret: %w", err)
+	}
+
+	data, ok := secret.Data[key]
+	if !ok || len(data) == 0 {
+		return nil, errors.New("credentials secret does not contain key " + key)
+	}
+	return data, nil
+}
+
+func getAWSConfigFromContext(ctx context.Context, t *testing.T) awsConfig {
+	c, ok := ctx.Value(awsConfigKey).(awsConfig)
+	assert.Equal(t, ok, true, "retrieve aws configuration from context: value not found for key %q", awsConfigKey)
+	return c
+}
+
+// awsCredentials renders a shared credentials file with the default profile. The session token is optional.
+func awsCredentials(id, key, token string) []byte {
+	creds := fmt.Sprintf(`
+[%s]
+aws_access_key_id = %s
+aws_secret_access_key = %s`, defaultProfile, id, key)
+
+	if token != "" {
+		creds += fmt.Sprintf(`
+aws_session_token = %s`, token)
+	}
+	return []byte(creds)
+}
@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/e2e-framework/pkg/env"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/envfuncs"
+)
+
+// cluster providers
+const (
+	providerKind       = "kind"
+	providerK3d        = "k3d"
+	providerKubeconfig = "kubeconfig"
+	providerEnvtest    = "envtest"
+)
+
+// koLocal is the ko repository which builds images into the local docker daemon
+const koLocal = "ko.local"
+
+// errNoNodes is returned when loading images into a cluster which cannot run pods
+var errNoNodes = errors.New("cluster has no nodes to run images")
+
+// clusterProvider creates or connects to the cluster of the test environment
+type clusterProvider interface {
+	// setup creates or connects to the cluster and configures the kubeconfig of the environment
+	setup() env.Func
+	// finish releases the cluster when all tests finished, deleting it if destroy is set and it was created by setup
+	finish(destroy bool) env.Func
+	// imageRepo is the repository of built images and the KO_DOCKER_REPO of ko. Images built with ko into koLocal and
+	// images built with docker are made available with loadImage.
+	imageRepo() string
+	// loadImage makes an image of the local docker daemon available to the cluster
+	loadImage(ctx context.Context, cfg *envconf.Config, image string) (context.Context, error)
+}
+
+// newClusterProvider returns the provider selected with CLUSTER_PROVIDER
+func newClusterProvider(c config) (clusterProvider, error) {
+	switch c.ClusterProvider {
+	case providerKind:
+		if c.KindCluster == "" {
+			return nil, errors.New("kind provider requires KIND_CLUSTER_NAME")
+		}
+		return &kindProvider{name: c.KindCluster, repo: c.DockerRepo}, nil
+	case providerK3d:
+		if c.K3dCluster == "" {
+			return nil, errors.New("k3d provider requires K3D_CLUSTER_NAME")
+		}
+		return &k3dProvider{name: c.K3dCluster}, nil
+	case providerKubeconfig:
+		if c.DockerRepo == "kind.local" || c.DockerRepo == koLocal {
+			return nil, fmt.Errorf("kubeconfig provider requires KO_DOCKER_REPO with a registry reachable from the cluster")
+		}
+		return &kubeconfigProvider{context: c.KubeContext, repo: c.DockerRepo}, nil
+	case providerEnvtest:
+		return &envtestProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported cluster provider %q", c.ClusterProvider)
+	}
+}
+
+// kindProvider creates a kind cluster unless it exists. With the kind.local repository, ko loads images itself.
+type kindProvider struct {
+	name string
+	repo string
+}
+
+func (k *kindProvider) setup() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		klog.Infof("setting up test environment with kind cluster %q", k.name)
+		return envfuncs.CreateKindCluster(k.name)(ctx, cfg)
+	}
+}
+
+func (k *kindProvider) finish(destroy bool) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		if !destroy {
+			return ctx, nil
+		}
+		return envfuncs.DestroyKindCluster(k.name)(ctx, cfg)
+	}
+}
+
+func (k *kindProvider) imageRepo() string {
+	return k.repo
+}
+
+func (k *kindProvider) loadImage(ctx context.Context, cfg *envconf.Config, image string) (context.Context, error) {
+	klog.Infof("loading image %q into kind cluster %q", image, k.name)
+	return envfuncs.LoadDockerImageToCluster(k.name, image)(ctx, cfg)
+}
+
+// k3dProvider creates a k3d cluster unless it exists and imports images from the local docker daemon
+type k3dProvider struct {
+	name string
+}
+
+func (k *k3dProvider) setup() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		klog.Infof("setting up test environment with k3d cluster %q", k.name)
+
+		if _, err := run(exec.CommandContext(ctx, "k3d", "cluster", "get", k.name)); err != nil {
+			klog.Infof("creating k3d cluster %q", k.name)
+			if _, err = run(exec.CommandContext(ctx, "k3d", "cluster", "create", k.name, "--wait")); err != nil {
+				return ctx, err
+			}
+		}
+
+		out, err := run(exec.CommandContext(ctx, "k3d", "kubeconfig", "write", k.name))
+		if err != nil {
+			return ctx, err
+		}
+
+		cfg.WithKubeconfigFile(strings.TrimSpace(out))
+		return ctx, checkCluster(ctx, cfg)
+	}
+}
+
+func (k *k3dProvider) finish(destroy bool) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		if !destroy {
+			return ctx, nil
+		}
+
+		klog.Infof("deleting k3d cluster %q", k.name)
+		_, err := run(exec.CommandContext(ctx, "k3d", "cluster", "delete", k.name))
+		return ctx, err
+	}
+}
+
+func (k *k3dProvider) imageRepo() string {
+	return koLocal
+}
+
+func (k *k3dProvider) loadImage(ctx context.Context, _ *envconf.Config, image string) (context.Context, error) {
+	klog.Infof("importing image %q into k3d cluster %q", image, k.name)
+	_, err := run(exec.CommandContext(ctx, "k3d", "image", "import", image, "--cluster", k.name))
+	return ctx, err
+}
+
+// kubeconfigProvider connects to an existing cluster, e.g. a shared development cluster, from the -kubeconfig flag
+// or KUBECONFIG. The cluster is never deleted and images are pushed to a registry.
+type kubeconfigProvider struct {
+	context string
+	repo    string
+}
+
+func (k *kubeconfigProvider) setup() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = cfg.KubeconfigFile()
+
+		kubecfg, err := rules.Load()
+		if err != nil {
+			return ctx, fmt.Errorf("load kubeconfig: %w", err)
+		}
+
+		if k.context != "" {
+			if _, ok := kubecfg.Contexts[k.context]; !ok {
+				return ctx, fmt.Errorf("kubeconfig context %q not found", k.context)
+			}
+			kubecfg.CurrentContext = k.context
+		}
+
+		klog.Infof("setting up test environment with existing cluster of context %q", kubecfg.CurrentContext)
+
+		// a single file with the selected context for helm and the clients of the tests
+		file, err := writeKubeconfig(kubecfg)
+		if err != nil {
+			return ctx, err
+		}
+
+		cfg.WithKubeconfigFile(file)
+		return ctx, checkCluster(ctx, cfg)
+	}
+}
+
+func (k *kubeconfigProvider) finish(bool) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		return ctx, os.Remove(cfg.KubeconfigFile())
+	}
+}
+
+func (k *kubeconfigProvider) imageRepo() string {
+	return k.repo
+}
+
+func (k *kubeconfigProvider) loadImage(ctx context.Context, _ *envconf.Config, image string) (context.Context, error) {
+	klog.Infof("pushing image %q", image)
+	_, err := run(exec.CommandContext(ctx, "docker", "push", image))
+	return ctx, err
+}
+
+// envtestProvider starts a local api server and etcd from KUBEBUILDER_ASSETS. The cluster has no nodes, so only
+// features which do not run pods can pass.
+type envtestProvider struct {
+	env *envtest.Environment
+}
+
+func (e *envtestProvider) setup() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		klog.Infof("setting up test environment with envtest api server")
+
+		e.env = &envtest.Environment{}
+		restCfg, err := e.env.Start()
+		if err != nil {
+			return ctx, fmt.Errorf("start envtest: %w", err)
+		}
+
+		file, err := writeKubeconfig(kubeconfigFor(restCfg))
+		if err != nil {
+			return ctx, err
+		}
+
+		cfg.WithKubeconfigFile(file)
+		return ctx, checkCluster(ctx, cfg)
+	}
+}
+
+// finish always stops the api server, it does not outlive the tests
+func (e *envtestProvider) finish(bool) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		klog.Infof("stopping envtest api server")
+		return ctx, errors.Join(e.env.Stop(), os.Remove(cfg.KubeconfigFile()))
+	}
+}
+
+func (e *envtestProvider) imageRepo() string {
+	return koLocal
+}
+
+func (e *envtestProvider) loadImage(ctx context.Context, _ *envconf.Config, _ string) (context.Context, error) {
+	return ctx, errNoNodes
+}
+
+// checkCluster fails early if the api server of the configured cluster is not reachable
+func checkCluster(ctx context.Context, cfg *envconf.Config) error {
+	client, err := cfg.NewClient()
+	if err != nil {
+		return err
+	}
+
+	var namespaces corev1.NamespaceList
+	if err = client.Resources().List(ctx, &namespaces); err != nil {
+		return fmt.Errorf("connect to cluster: %w", err)
+	}
+	return nil
+}
+
+// kubeconfigFor returns a kubeconfig with the server and client certificates of restCfg
+func kubeconfigFor(restCfg *rest.Config) *clientcmdapi.Config {
+	const name = "envtest"
+
+	kubecfg := clientcmdapi.NewConfig()
+	kubecfg.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   restCfg.Host,
+		CertificateAuthorityData: restCfg.CAData,
+	}
+	kubecfg.AuthInfos[name] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: restCfg.CertData,
+		ClientKeyData:         restCfg.KeyData,
+		Token:                 restCfg.BearerToken,
+	}
+	kubecfg.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  name,
+		AuthInfo: name,
+	}
+	kubecfg.CurrentContext = name
+
+	return kubecfg
+}
+
+func writeKubeconfig(kubecfg *clientcmdapi.Config) (string, error) {
+	f, err := os.CreateTemp("", "e2e-kubeconfig")
+	if err != nil {
+		return "", fmt.Errorf("create kubeconfig: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("create kubeconfig: %w", err)
+	}
+
+	if err = clientcmd.WriteToFile(*kubecfg, f.Name()); err != nil {
+		return "", fmt.Errorf("write kubeconfig: %w", err)
+	}
+	return f.Name(), nil
+}
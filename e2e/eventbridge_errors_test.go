@@ -0,0 +1,191 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ebv1alpha "github.com/aws-controllers-k8s/eventbridge-controller/apis/v1alpha1"
+	ackcore "github.com/aws-controllers-k8s/runtime/apis/core/v1alpha1"
+	"github.com/aws/aws-sdk-go/aws"
+	ebsvcsdk "github.com/aws/aws-sdk-go/service/eventbridge"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+func invalidEventBusRecoverable() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		name := envconf.RandomName("e2e-invalid", 20)
+		bus := eventBusFor(name, ns)
+		bus.Spec.Name = aws.String("e2e invalid bus!")
+		retainOnDelete(&bus)
+
+		klog.Infof("creating event bus %q with invalid name %q in namespace %q", name, *bus.Spec.Name, ns)
+		err := r.Create(ctx, &bus)
+		assert.NilError(t, err)
+
+		assertRecoverable(ctx, t, r, &bus, "ValidationException")
+		deleteAndWait(ctx, t, r, &bus)
+
+		return ctx
+	}
+}
+
+func deniedEventBusRecoverable() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if getConfigFromContext(ctx).FakeEventBridge == "" {
+			t.Skip("missing permissions can only be simulated with the fake eventbridge")
+		}
+
+		r := ebResources(t, cfg)
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		name := envconf.RandomName(deniedBusPrefix, 20)
+		bus := eventBusFor(name, ns)
+		retainOnDelete(&bus)
+
+		klog.Infof("creating event bus %q without permissions in namespace %q", name, ns)
+		err := r.Create(ctx, &bus)
+		assert.NilError(t, err)
+
+		assertRecoverable(ctx, t, r, &bus, "AccessDeniedException")
+		deleteAndWait(ctx, t, r, &bus)
+
+		return ctx
+	}
+}
+
+// duplicateEventBusRejected asserts that a second resource using the name of an existing event bus reports an
+// ACK.Terminal or ACK.Recoverable condition and leaves the existing bus alone
+func duplicateEventBusRejected() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		busname := envconf.RandomName("e2e-duplicate", 20)
+		original := eventBusFor(busname, ns)
+
+		klog.Infof("creating event bus %q in namespace %q", busname, ns)
+		err := r.Create(ctx, &original)
+		assert.NilError(t, err)
+		assertSynced(ctx, t, r, &original)
+
+		duplicate := eventBusFor(envconf.RandomName("e2e-duplicate", 20), ns)
+		duplicate.Spec.Name = aws.String(busname)
+		// the original resource deletes the bus
+		retainOnDelete(&duplicate)
+
+		klog.Infof("creating event bus %q with existing bus name %q in namespace %q", duplicate.Name, busname, ns)
+		err = r.Create(ctx, &duplicate)
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for %q in namespace %q to report a terminal or recoverable condition", duplicate.Name, ns)
+		terminal := ackConditionMatch(ackcore.ConditionTypeTerminal, corev1.ConditionTrue, "ResourceAlreadyExistsException")
+		recoverable := ackConditionMatch(ackcore.ConditionTypeRecoverable, corev1.ConditionTrue, "ResourceAlreadyExistsException")
+		match := conditions.New(r).ResourceMatch(&duplicate, func(obj k8s.Object) bool {
+			return terminal(obj) || recoverable(obj)
+		})
+		waitCtx, cancel := context.WithTimeout(ctx, ackConditionTimeout)
+		defer cancel()
+		err = wait.For(match, wait.WithContext(waitCtx))
+		assert.NilError(t, err, "duplicate event bus %q reported no conflict", duplicate.Name)
+
+		err = r.Get(ctx, original.Name, ns, &original)
+		assert.NilError(t, err)
+		assert.Assert(t, ackConditionMatch(ackcore.ConditionTypeResourceSynced, corev1.ConditionTrue, "")(&original),
+			"event bus %q is no longer synced", original.Name)
+
+		deleteAndWait(ctx, t, r, &duplicate)
+
+		eb := ebSDKClient(ctx, t)
+		klog.Infof("asserting event bus %q still exists in aws service control plane", busname)
+		_, err = eb.DescribeEventBusWithContext(ctx, &ebsvcsdk.DescribeEventBusInput{Name: aws.String(busname)})
+		assert.NilError(t, err)
+
+		deleteAndWait(ctx, t, r, &original)
+
+		klog.Infof("asserting event bus %q is deleted in aws service control plane", busname)
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			resp, err := eb.ListEventBusesWithContext(ctx, &ebsvcsdk.ListEventBusesInput{NamePrefix: aws.String(busname)})
+			if err != nil {
+				return false, err
+			}
+			return len(resp.EventBuses) == 0, nil
+		}, wait.WithTimeout(time.Minute))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+func invalidRuleTerminal() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+		ns := getTestNamespaceFromContext(ctx, t)
+		busname := ctx.Value(testbusCtxKey).(string)
+
+		tests := []struct {
+			name    string
+			pattern string
+			message string
+		}{
+			{name: "invalid pattern", pattern: `{"source":`, message: "InvalidEventPatternException"},
+			{name: "missing pattern", pattern: "", message: "spec.eventPattern"},
+		}
+
+		for _, tt := range tests {
+			rule := ruleFor(envconf.RandomName("e2e-invalid", 20), ns, busname, tt.pattern)
+			retainOnDelete(&rule)
+
+			klog.Infof("creating rule %q with %s in namespace %q", rule.Name, tt.name, ns)
+			err := r.Create(ctx, &rule)
+			assert.NilError(t, err)
+
+			assertTerminal(ctx, t, r, &rule, tt.message)
+			deleteAndWait(ctx, t, r, &rule)
+		}
+
+		return ctx
+	}
+}
+
+func ebResources(t *testing.T, cfg *envconf.Config) *resources.Resources {
+	r, err := resources.New(cfg.Client().RESTConfig())
+	assert.NilError(t, err)
+
+	err = ebv1alpha.AddToScheme(r.GetScheme())
+	assert.NilError(t, err)
+
+	return r
+}
+
+// retainOnDelete skips the deletion in aws, so that resources which were never created can be deleted
+func retainOnDelete(obj k8s.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[ackcore.AnnotationDeletionPolicy] = string(ackcore.DeletionPolicyRetain)
+	obj.SetAnnotations(annotations)
+}
+
+func deleteAndWait(ctx context.Context, t *testing.T, r *resources.Resources, obj k8s.Object) {
+	t.Helper()
+
+	klog.Infof("deleting %q in namespace %q", obj.GetName(), obj.GetNamespace())
+	err := r.Delete(ctx, obj)
+	assert.NilError(t, err)
+
+	err = wait.For(conditions.New(r).ResourceDeleted(obj), wait.WithTimeout(time.Minute))
+	assert.NilError(t, err)
+}
@@ -3,25 +3,27 @@ package e2e
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	ebv1alpha "github.com/aws-controllers-k8s/eventbridge-controller/apis/v1alpha1"
-	ackcore "github.com/aws-controllers-k8s/runtime/apis/core/v1alpha1"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	ecrsvcsdk "github.com/aws/aws-sdk-go/service/ecrpublic"
 	ebsvcsdk "github.com/aws/aws-sdk-go/service/eventbridge"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/vladimirvivien/gexe"
 	"gotest.tools/v3/assert"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimachinerywait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/e2e-framework/klient/k8s"
-	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
 	"sigs.k8s.io/e2e-framework/klient/wait"
 	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
@@ -34,7 +36,7 @@ const (
 	eventbridgeRegistry     = "public.ecr.aws"
 	eventbridgeChart        = "oci://public.ecr.aws/aws-controllers-k8s/eventbridge-chart"
 	eventbridgeChartVersion = "1.0.2"
-	eventbridgeDeployment   = "ack-eventbridge-controller"
+	eventbridgeRelease      = "ack-eventbridge-controller"
 	controllerNamespace     = "ack-system"
 	eventbridgeConfig       = "./testdata/eventbridge.config"
 
@@ -42,26 +44,37 @@ const (
 	secretName = "eventbridge-credentials"
 	secretKey  = "credentials"
 
-	// 	test variables
-	testbusCtxKey = "testbus"
+	// fake eventbridge api (used when FAKE_EVENTBRIDGE_IMAGE is set)
+	fakeEventBridgeName = "fake-eventbridge"
+	fakeEventBridgePort = 4010
+	fakeRegion          = "us-east-1"
+	fakeAccessKey       = "fake-access-key"
+	fakeSecretKey       = "fake-secret-key"
+	// the fake denies access to event buses with this prefix
+	deniedBusPrefix = "e2e-denied"
 )
 
-var awscfg awsConfig
+type eventbridgeCtxKey string
 
-type awsConfig struct {
-	Region       string `envconfig:"AWS_DEFAULT_REGION" required:"true"`
-	AccessKey    string `envconfig:"AWS_ACCESS_KEY_ID" required:"true"`
-	SecretKey    string `envconfig:"AWS_SECRET_ACCESS_KEY" required:"true"`
-	SessionToken string `envconfig:"AWS_SESSION_TOKEN" required:"true"`
-}
+// test variables
+const (
+	testbusCtxKey         = eventbridgeCtxKey("testbus")
+	testruleCtxKey        = eventbridgeCtxKey("testrule")
+	fakeEndpointCtxKey    = eventbridgeCtxKey("fakeendpoint")
+	fakePortForwardCtxKey = eventbridgeCtxKey("fakeportforward")
+)
 
 func setupEventBridge() features.Func {
-	steps := []features.Func{
-		createCredentials(),
-		setupController(),
-	}
-
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		steps := []features.Func{
+			createCredentials(),
+			setupController(),
+		}
+
+		if getConfigFromContext(ctx).FakeEventBridge != "" {
+			steps = append([]features.Func{setupFakeEventBridge()}, steps...)
+		}
+
 		for _, step := range steps {
 			ctx = step(ctx, t, cfg)
 		}
@@ -70,11 +83,60 @@ func setupEventBridge() features.Func {
 	}
 }
 
-func createCredentials() features.Func {
+// setupFakeEventBridge deploys the fake eventbridge api in the test namespace and forwards a local port to it so that
+// the controller and ebSDKClient can be pointed to it
+func setupFakeEventBridge() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
-		err := envconfig.Process("", &awscfg)
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		deployment, service := fakeEventBridgeFor(ns, getConfigFromContext(ctx).FakeEventBridge)
+		klog.Infof("creating fake eventbridge %q in namespace %q", fakeEventBridgeName, ns)
+		err := cfg.Client().Resources().Create(ctx, &deployment)
+		assert.NilError(t, err)
+
+		err = cfg.Client().Resources().Create(ctx, &service)
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for fake eventbridge %q in namespace %q to become ready", fakeEventBridgeName, ns)
+		ready := conditions.New(cfg.Client().Resources()).DeploymentConditionMatch(&deployment, appsv1.DeploymentAvailable, corev1.ConditionTrue)
+		err = wait.For(ready, wait.WithTimeout(time.Minute))
+		assert.NilError(t, err)
+
+		pod, err := podForDeployment(ctx, cfg, ns, fakeEventBridgeName)
+		assert.NilError(t, err)
+
+		port, stop, err := portForward(ctx, cfg, ns, pod, fakeEventBridgePort)
 		assert.NilError(t, err)
 
+		ctx = context.WithValue(ctx, fakeEndpointCtxKey, fmt.Sprintf("http://127.0.0.1:%d", port))
+		return context.WithValue(ctx, fakePortForwardCtxKey, stop)
+	}
+}
+
+func createCredentials() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		var (
+			awscfg awsConfig
+			data   []byte
+			err    error
+		)
+
+		if getConfigFromContext(ctx).FakeEventBridge != "" {
+			awscfg = awsConfig{
+				Region:    fakeRegion,
+				AccessKey: fakeAccessKey,
+				SecretKey: fakeSecretKey,
+			}
+			data = awsCredentials(awscfg.AccessKey, awscfg.SecretKey, "")
+		} else {
+			err = envconfig.Process("", &awscfg)
+			assert.NilError(t, err)
+
+			data, err = resolveAWSCredentials(ctx, cfg, &awscfg)
+			assert.NilError(t, err)
+		}
+		ctx = context.WithValue(ctx, awsConfigKey, awscfg)
+
 		ns := getTestNamespaceFromContext(ctx, t)
 
 		secret := corev1.Secret{
@@ -84,7 +146,7 @@ func createCredentials() features.Func {
 			},
 			Immutable: pointer.Bool(true),
 			Data: map[string][]byte{
-				secretKey: awsCredentials(awscfg.AccessKey, awscfg.SecretKey, awscfg.SessionToken),
+				secretKey: data,
 			},
 		}
 
@@ -96,117 +158,439 @@ func createCredentials() features.Func {
 	}
 }
 
-func awsCredentials(id, key, token string) []byte {
-	return []byte(fmt.Sprintf(`
-[default]
-aws_access_key_id = %s
-aws_secret_access_key = %s
-aws_session_token = %s`, id, key, token))
-}
-
 func setupController() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
-		ecr := ecrSDKClient(t)
-		klog.Infof("retrieving ecr authorization token")
-		resp, err := ecr.GetAuthorizationTokenWithContext(ctx, &ecrsvcsdk.GetAuthorizationTokenInput{})
-		assert.NilError(t, err)
+		ns := getTestNamespaceFromContext(ctx, t)
+		awscfg := getAWSConfigFromContext(ctx, t)
+		c := getConfigFromContext(ctx)
+		release := eventbridgeReleaseFor(ns)
+
+		args := []string{
+			"--create-namespace",
+			"-f", eventbridgeConfig,
+			"--set", fmt.Sprintf("fullnameOverride=%s", release),
+			"--set", fmt.Sprintf("aws.region=%s", awscfg.Region),
+			"--set", fmt.Sprintf("aws.credentials.profile=%s", awscfg.secretProfile()),
+		}
 
-		b, err := base64.StdEncoding.DecodeString(*resp.AuthorizationData.AuthorizationToken)
-		assert.NilError(t, err, "decode ecr authorization token")
-		// e.g. AWS:eyJwYXlsb2...
-		token := strings.SplitN(string(b), ":", 2)
-		assert.Equal(t, len(token), 2, "ecr authorization token validation")
+		var username, password string
+		if c.FakeEventBridge != "" {
+			klog.Infof("using fake eventbridge endpoint %q", fakeEndpoint(ns))
+			// not waiting since the controller only becomes ready after patchController pointed it to the fake sts
+			// endpoint
+			args = append(args, "--set", fmt.Sprintf("aws.endpoint_url=%s", fakeEndpoint(ns)))
+		} else {
+			// the public chart can also be pulled anonymously, authenticated pulls have higher rate limits
+			if c.ChartRegistryLogin {
+				username, password = ecrCredentials(ctx, t)
+			}
+			args = append(args, "--wait")
+		}
 
-		klog.Infof("logging in to ecr registry %q", eventbridgeRegistry)
-		result := gexe.Pipe(fmt.Sprintf("echo -n %s", token[1]), "docker login --username AWS --password-stdin public.ecr.aws")
-		assert.NilError(t, result.LastProc().Err(), "docker public.ecr.aws login")
+		klog.Infof("creating helm registry config for registry %q (authenticated: %t)", eventbridgeRegistry, username != "")
+		registryConfig, cleanup, err := helmRegistryConfig(ctx, eventbridgeRegistry, username, password)
+		assert.NilError(t, err)
+		defer cleanup()
+		args = append(args, "--registry-config", registryConfig)
 
 		hm := helm.New(cfg.KubeconfigFile())
-		ns := getTestNamespaceFromContext(ctx, t)
-		klog.Infof("installing eventbridge controller %q in namespace %q with version %q", eventbridgeDeployment, ns, eventbridgeChartVersion)
+		klog.Infof("installing eventbridge controller %q in namespace %q with version %q", release, ns, eventbridgeChartVersion)
 		opts := []helm.Option{
-			helm.WithName(eventbridgeDeployment),
+			helm.WithName(release),
 			helm.WithNamespace(ns),
 			helm.WithChart(eventbridgeChart),
 			helm.WithVersion(eventbridgeChartVersion),
-			helm.WithArgs(
-				"--create-namespace",
-				"-f", eventbridgeConfig,
-				"--set", fmt.Sprintf("aws.region=%s", awscfg.Region),
-				"--wait",
-			),
+			helm.WithArgs(args...),
 		}
 
 		err = hm.RunInstall(opts...)
 		assert.NilError(t, err)
 
+		if c.FakeEventBridge != "" {
+			patchController(ctx, t, cfg, ns)
+		}
+
 		return ctx
 	}
 }
 
+// ecrCredentials returns the username and password for the public ecr registry
+func ecrCredentials(ctx context.Context, t *testing.T) (string, string) {
+	ecr := ecrSDKClient(ctx, t)
+	klog.Infof("retrieving ecr authorization token")
+	resp, err := ecr.GetAuthorizationTokenWithContext(ctx, &ecrsvcsdk.GetAuthorizationTokenInput{})
+	assert.NilError(t, err)
+
+	b, err := base64.StdEncoding.DecodeString(*resp.AuthorizationData.AuthorizationToken)
+	assert.NilError(t, err, "decode ecr authorization token")
+	// e.g. AWS:eyJwYXlsb2...
+	token := strings.SplitN(string(b), ":", 2)
+	assert.Equal(t, len(token), 2, "ecr authorization token validation")
+
+	return token[0], token[1]
+}
+
+// eventbridgeReleaseFor returns the name of the controller release and deployment in namespace. Releases of parallel
+// features must not share a name, the chart names cluster scoped resources after it.
+func eventbridgeReleaseFor(namespace string) string {
+	return eventbridgeRelease + "-" + namespace
+}
+
+func fakeEndpoint(namespace string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", fakeEventBridgeName, namespace, fakeEventBridgePort)
+}
+
+// patchController adds the controller flags to use the fake eventbridge api for sts and to allow its http endpoint.
+// The chart does not expose them as values.
+func patchController(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) {
+	name := eventbridgeReleaseFor(namespace)
+
+	var deployment appsv1.Deployment
+	err := cfg.Client().Resources().Get(ctx, name, namespace, &deployment)
+	assert.NilError(t, err)
+
+	klog.Infof("patching eventbridge controller %q in namespace %q to use the fake eventbridge", name, namespace)
+	containers := deployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == "controller" {
+			containers[i].Args = append(containers[i].Args,
+				"--aws-identity-endpoint-url", fakeEndpoint(namespace),
+				"--allow-unsafe-aws-endpoint-urls",
+			)
+		}
+	}
+
+	err = cfg.Client().Resources().Update(ctx, &deployment)
+	assert.NilError(t, err)
+
+	klog.Infof("waiting for eventbridge controller %q in namespace %q to become ready", name, namespace)
+	ready := conditions.New(cfg.Client().Resources()).ResourceMatch(&deployment, func(object k8s.Object) bool {
+		d := object.(*appsv1.Deployment)
+		return d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == *d.Spec.Replicas &&
+			d.Status.AvailableReplicas == *d.Spec.Replicas && d.Status.Replicas == *d.Spec.Replicas
+	})
+	err = wait.For(ready, wait.WithTimeout(3*time.Minute))
+	assert.NilError(t, err)
+}
+
 func eventbusCreated() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
-		r, err := resources.New(cfg.Client().RESTConfig())
-		assert.NilError(t, err)
-
-		err = ebv1alpha.AddToScheme(r.GetScheme())
-		assert.NilError(t, err)
+		r := ebResources(t, cfg)
 
 		ns := getTestNamespaceFromContext(ctx, t)
+		region := getAWSConfigFromContext(ctx, t).Region
 
 		busname := envconf.RandomName("e2e-feature", 15)
 		ctx = context.WithValue(ctx, testbusCtxKey, busname)
 
-		bus := eventBusFor(busname, ns)
+		tags := map[string]string{"team": "e2e", "env": "test"}
+		bus := eventBusFor(busname, ns, tagsFor(tags)...)
 
 		klog.Infof("creating event bus %q in namespace %q", busname, ns)
-		err = r.Create(ctx, &bus)
+		err := r.Create(ctx, &bus)
 		assert.NilError(t, err)
 
 		// check if ack resource is synchronized
 		klog.Infof("waiting for event bus %q in namespace %q to become ready", busname, ns)
-		syncedCondition := conditions.New(r).ResourceMatch(&bus, func(bus k8s.Object) bool {
-			for _, cond := range bus.(*ebv1alpha.EventBus).Status.Conditions {
-				if cond.Type == ackcore.ConditionTypeResourceSynced && cond.Status == corev1.ConditionTrue {
-					return true
-				}
-			}
-			return false
-		})
-
-		err = wait.For(syncedCondition, wait.WithTimeout(3*time.Minute)) // controller takes a while on initial start even after reporting available
-		assert.NilError(t, err)
+		assertSynced(ctx, t, r, &bus)
+		assertACKMetadata(t, &bus, region)
 
 		// check if it exists in aws service control plane
-		eb := ebSDKClient(t)
+		eb := ebSDKClient(ctx, t)
 		klog.Infof("asserting event bus %q in namespace %q exists in aws service control plane", busname, ns)
 		input := ebsvcsdk.DescribeEventBusInput{Name: aws.String(busname)}
 		resp, err := eb.DescribeEventBusWithContext(ctx, &input)
 		assert.NilError(t, err)
 		assert.Equal(t, *resp.Name, busname)
 
+		klog.Infof("asserting tags of event bus %q in namespace %q exist in aws service control plane", busname, ns)
+		ok, err := busTagsMatch(eb, *resp.Arn, tags)(ctx)
+		assert.NilError(t, err)
+		assert.Assert(t, ok, "event bus tags not found in aws service control plane")
+
 		return ctx
 	}
 }
 
-func eventbusDeleted() features.Func {
+func eventbusTagsUpdated() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+
+		ns := getTestNamespaceFromContext(ctx, t)
+		busname := ctx.Value(testbusCtxKey).(string)
+
+		var (
+			arn     string
+			desired map[string]string
+		)
+
+		klog.Infof("updating tags of event bus %q in namespace %q", busname, ns)
+		// the controller concurrently updates the resource
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var bus ebv1alpha.EventBus
+			if err := r.Get(ctx, busname, ns, &bus); err != nil {
+				return err
+			}
+
+			if bus.Status.ACKResourceMetadata == nil || bus.Status.ACKResourceMetadata.ARN == nil {
+				return errors.New("event bus status is missing resource arn")
+			}
+			arn = string(*bus.Status.ACKResourceMetadata.ARN)
+
+			// the spec also contains the default tags added by the controller which must be preserved
+			desired = make(map[string]string)
+			for _, tag := range bus.Spec.Tags {
+				desired[*tag.Key] = *tag.Value
+			}
+			delete(desired, "team")
+			desired["env"] = "staging"
+			desired["owner"] = "e2e-suite"
+			bus.Spec.Tags = tagsFor(desired)
+
+			return r.Update(ctx, &bus)
+		})
+		assert.NilError(t, err)
+
+		eb := ebSDKClient(ctx, t)
+		klog.Infof("asserting tags of event bus %q in namespace %q are updated in aws service control plane", busname, ns)
+		err = wait.For(busTagsMatch(eb, arn, desired, "team"), wait.WithTimeout(time.Minute))
+		assert.NilError(t, err)
+
+		// change the tags outside of kubernetes and expect the controller to revert the drift
+		klog.Infof("changing tags of event bus %q in aws service control plane", busname)
+		_, err = eb.TagResourceWithContext(ctx, &ebsvcsdk.TagResourceInput{
+			ResourceARN: aws.String(arn),
+			Tags: []*ebsvcsdk.Tag{
+				{Key: aws.String("env"), Value: aws.String("drifted")},
+				{Key: aws.String("team"), Value: aws.String("drifted")},
+			},
+		})
+		assert.NilError(t, err)
+
+		_, err = eb.UntagResourceWithContext(ctx, &ebsvcsdk.UntagResourceInput{
+			ResourceARN: aws.String(arn),
+			TagKeys:     []*string{aws.String("owner")},
+		})
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for controller to revert tags of event bus %q in namespace %q", busname, ns)
+		err = wait.For(busTagsMatch(eb, arn, desired, "team"), wait.WithTimeout(3*time.Minute))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+// busTagsMatch returns a condition which is met when the resource in the aws service control plane has all tags in
+// want and none of the absent tag keys. Other tags, e.g. added by the controller, are ignored.
+func busTagsMatch(eb *ebsvcsdk.EventBridge, arn string, want map[string]string, absent ...string) apimachinerywait.ConditionWithContextFunc {
+	return func(ctx context.Context) (bool, error) {
+		resp, err := eb.ListTagsForResourceWithContext(ctx, &ebsvcsdk.ListTagsForResourceInput{
+			ResourceARN: aws.String(arn),
+		})
+		if err != nil {
+			return false, fmt.Errorf("list tags: %w", err)
+		}
+
+		got := make(map[string]string)
+		for _, tag := range resp.Tags {
+			got[*tag.Key] = *tag.Value
+		}
+
+		for k, v := range want {
+			if value, ok := got[k]; !ok || value != v {
+				return false, nil
+			}
+		}
+
+		for _, k := range absent {
+			if _, ok := got[k]; ok {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+}
+
+func ruleCreated() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
-		r, err := resources.New(cfg.Client().RESTConfig())
+		r := ebResources(t, cfg)
+
+		ns := getTestNamespaceFromContext(ctx, t)
+		region := getAWSConfigFromContext(ctx, t).Region
+		busname := ctx.Value(testbusCtxKey).(string)
+
+		var bus ebv1alpha.EventBus
+		err := r.Get(ctx, busname, ns, &bus)
 		assert.NilError(t, err)
+		busMetadata := assertACKMetadata(t, &bus, region)
+
+		rulename := envconf.RandomName("e2e-rule", 15)
+		ctx = context.WithValue(ctx, testruleCtxKey, rulename)
+
+		target := ebv1alpha.Target{
+			ID:  aws.String("e2e-target"),
+			ARN: aws.String(fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, *busMetadata.OwnerAccountID, rulename)),
+			RetryPolicy: &ebv1alpha.RetryPolicy{
+				MaximumRetryAttempts: aws.Int64(3),
+			},
+		}
+		rule := ruleFor(rulename, ns, busname, `{"source":["e2e.created"]}`, &target)
 
-		err = ebv1alpha.AddToScheme(r.GetScheme())
+		klog.Infof("creating rule %q for event bus %q in namespace %q", rulename, busname, ns)
+		err = r.Create(ctx, &rule)
 		assert.NilError(t, err)
 
+		// check if ack resource is synchronized
+		klog.Infof("waiting for rule %q in namespace %q to become ready", rulename, ns)
+		assertSynced(ctx, t, r, &rule)
+		assertACKMetadata(t, &rule, region)
+
+		// check if rule and targets exist in aws service control plane
+		eb := ebSDKClient(ctx, t)
+		klog.Infof("asserting rule %q in namespace %q exists in aws service control plane", rulename, ns)
+		resp, err := eb.DescribeRuleWithContext(ctx, &ebsvcsdk.DescribeRuleInput{
+			Name:         aws.String(rulename),
+			EventBusName: aws.String(busname),
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, *resp.Name, rulename)
+		assert.Equal(t, *resp.EventBusName, busname)
+		assertEqualJSON(t, *resp.EventPattern, *rule.Spec.EventPattern)
+
+		klog.Infof("asserting targets of rule %q in namespace %q exist in aws service control plane", rulename, ns)
+		targets, err := eb.ListTargetsByRuleWithContext(ctx, &ebsvcsdk.ListTargetsByRuleInput{
+			Rule:         aws.String(rulename),
+			EventBusName: aws.String(busname),
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, len(targets.Targets), 1)
+		assert.Equal(t, *targets.Targets[0].Id, *target.ID)
+		assert.Equal(t, *targets.Targets[0].Arn, *target.ARN)
+		assert.Equal(t, *targets.Targets[0].RetryPolicy.MaximumRetryAttempts, *target.RetryPolicy.MaximumRetryAttempts)
+
+		return ctx
+	}
+}
+
+func ruleUpdated() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+
+		ns := getTestNamespaceFromContext(ctx, t)
+		busname := ctx.Value(testbusCtxKey).(string)
+		rulename := ctx.Value(testruleCtxKey).(string)
+
+		pattern := `{"source":["e2e.updated"]}`
+
+		klog.Infof("updating event pattern of rule %q in namespace %q", rulename, ns)
+		// the controller concurrently updates the resource
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var rule ebv1alpha.Rule
+			if err := r.Get(ctx, rulename, ns, &rule); err != nil {
+				return err
+			}
+
+			rule.Spec.EventPattern = aws.String(pattern)
+			return r.Update(ctx, &rule)
+		})
+		assert.NilError(t, err)
+
+		eb := ebSDKClient(ctx, t)
+		klog.Infof("asserting event pattern of rule %q in namespace %q is updated in aws service control plane", rulename, ns)
+		err = wait.For(rulePatternEquals(eb, busname, rulename, pattern), wait.WithTimeout(time.Minute))
+		assert.NilError(t, err)
+
+		// change the rule outside of kubernetes and expect the controller to revert the drift
+		klog.Infof("changing event pattern of rule %q in aws service control plane", rulename)
+		_, err = eb.PutRuleWithContext(ctx, &ebsvcsdk.PutRuleInput{
+			Name:         aws.String(rulename),
+			EventBusName: aws.String(busname),
+			EventPattern: aws.String(`{"source":["e2e.drifted"]}`),
+		})
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for controller to revert event pattern of rule %q in namespace %q", rulename, ns)
+		err = wait.For(rulePatternEquals(eb, busname, rulename, pattern), wait.WithTimeout(3*time.Minute))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+func ruleDeleted() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+
+		ns := getTestNamespaceFromContext(ctx, t)
+		busname := ctx.Value(testbusCtxKey).(string)
+		rulename := ctx.Value(testruleCtxKey).(string)
+
+		rule := ruleFor(rulename, ns, busname, "")
+		klog.Infof("deleting rule %q in namespace %q", rulename, ns)
+		err := r.Delete(ctx, &rule)
+		assert.NilError(t, err)
+
+		// check if it is deleted in aws service control plane
+		eb := ebSDKClient(ctx, t)
+		klog.Infof("asserting rule %q in namespace %q is deleted in aws service control plane", rulename, ns)
+		ruleDeleted := func(ctx context.Context) (bool, error) {
+			_, err := eb.DescribeRuleWithContext(ctx, &ebsvcsdk.DescribeRuleInput{
+				Name:         aws.String(rulename),
+				EventBusName: aws.String(busname),
+			})
+
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == ebsvcsdk.ErrCodeResourceNotFoundException {
+				return true, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("describe rule: %w", err)
+			}
+
+			return false, nil
+		}
+		err = wait.For(ruleDeleted, wait.WithTimeout(time.Minute))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+// rulePatternEquals returns a condition which is met when the event pattern of the rule in the aws service control
+// plane is equal to pattern
+func rulePatternEquals(eb *ebsvcsdk.EventBridge, bus, rule, pattern string) apimachinerywait.ConditionWithContextFunc {
+	return func(ctx context.Context) (bool, error) {
+		resp, err := eb.DescribeRuleWithContext(ctx, &ebsvcsdk.DescribeRuleInput{
+			Name:         aws.String(rule),
+			EventBusName: aws.String(bus),
+		})
+		if err != nil {
+			return false, fmt.Errorf("describe rule: %w", err)
+		}
+
+		if resp.EventPattern == nil {
+			return false, nil
+		}
+		return equalJSON(*resp.EventPattern, pattern), nil
+	}
+}
+
+func eventbusDeleted() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		r := ebResources(t, cfg)
+
 		ns := getTestNamespaceFromContext(ctx, t)
 
 		busname := ctx.Value(testbusCtxKey).(string)
 		bus := eventBusFor(busname, ns)
 		klog.Infof("deleting event bus %q in namespace %q", busname, ns)
-		err = r.Delete(ctx, &bus)
+		err := r.Delete(ctx, &bus)
 		assert.NilError(t, err)
 
 		// check if it is deleted in aws service control plane
-		eb := ebSDKClient(t)
+		eb := ebSDKClient(ctx, t)
 		klog.Infof("asserting event bus %q in namespace %q is deleted in aws service control plane", busname, ns)
 		busDeleted := func(ctx context.Context) (bool, error) {
 			resp, err := eb.ListEventBusesWithContext(ctx, &ebsvcsdk.ListEventBusesInput{
@@ -227,7 +611,10 @@ func eventbusDeleted() features.Func {
 
 func teardownEventBridge() features.Func {
 	steps := []features.Func{
+		// before the controller and its logs are gone
+		collectFailedArtifacts(),
 		uninstallController(),
+		stopFakeEventBridge(),
 	}
 
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
@@ -243,9 +630,10 @@ func uninstallController() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 		hm := helm.New(cfg.KubeconfigFile())
 		ns := getTestNamespaceFromContext(ctx, t)
-		klog.Infof("uninstalling eventbridge controller %q in namespace %q", eventbridgeDeployment, ns)
+		release := eventbridgeReleaseFor(ns)
+		klog.Infof("uninstalling eventbridge controller %q in namespace %q", release, ns)
 		opts := []helm.Option{
-			helm.WithName(eventbridgeDeployment),
+			helm.WithName(release),
 			helm.WithNamespace(ns),
 		}
 		err := hm.RunUninstall(opts...)
@@ -254,3 +642,14 @@ func uninstallController() features.Func {
 		return ctx
 	}
 }
+
+func stopFakeEventBridge() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if stop, ok := ctx.Value(fakePortForwardCtxKey).(func()); ok {
+			klog.Infof("stopping port forwarding to fake eventbridge %q", fakeEventBridgeName)
+			stop()
+		}
+
+		return ctx
+	}
+}
@@ -0,0 +1,295 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+)
+
+// errJobFailed is returned by runJob if the job did not complete successfully
+var errJobFailed = errors.New("job failed")
+
+// podExec runs command in a container of a running pod and returns its stdout. The error contains stderr if the
+// command fails.
+func podExec(ctx context.Context, cfg *envconf.Config, namespace, pod, container string, command ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	klog.Infof("running %q in container %q of pod %q in namespace %q", strings.Join(command, " "), container, pod, namespace)
+	err := cfg.Client().Resources().ExecInPod(ctx, namespace, pod, container, command, &stdout, &stderr)
+	if err != nil {
+		return stdout.String(), fmt.Errorf("exec in pod %q: %w: %s", pod, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// runJob runs command once in a new pod of the given image, e.g. to reach services from inside the namespace, and
+// returns the pod logs. The job is deleted when it finished.
+func runJob(ctx context.Context, cfg *envconf.Config, namespace, name, image string, command ...string) (string, error) {
+	labels := copyMap(commonLabels)
+	labels["app"] = name
+
+	var backoff int32
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:            name,
+							Image:           image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         command,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	r := cfg.Client().Resources()
+
+	klog.Infof("running %q in job %q in namespace %q", strings.Join(command, " "), name, namespace)
+	if err := r.Create(ctx, &job); err != nil {
+		return "", fmt.Errorf("create job: %w", err)
+	}
+	defer func() {
+		if err := r.Delete(ctx, &job, resources.WithDeletePropagation(string(metav1.DeletePropagationBackground))); err != nil {
+			klog.Infof("could not delete job %q: %v", name, err)
+		}
+	}()
+
+	var failed bool
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute*2)
+	defer cancel()
+	err := wait.For(func(ctx context.Context) (bool, error) {
+		if err := r.Get(ctx, name, namespace, &job); err != nil {
+			return false, err
+		}
+
+		for _, c := range job.Status.Conditions {
+			if c.Status != corev1.ConditionTrue {
+				continue
+			}
+
+			switch c.Type {
+			case batchv1.JobComplete:
+				return true, nil
+			case batchv1.JobFailed:
+				failed = true
+				return true, nil
+			}
+		}
+		return false, nil
+	}, wait.WithContext(waitCtx))
+	if err != nil {
+		return "", fmt.Errorf("wait for job: %w", err)
+	}
+
+	logs, err := jobLogs(ctx, cfg, &job)
+	if err != nil {
+		return "", err
+	}
+
+	if failed {
+		return logs, fmt.Errorf("%w: %s", errJobFailed, strings.TrimSpace(logs))
+	}
+	return logs, nil
+}
+
+func jobLogs(ctx context.Context, cfg *envconf.Config, job k8s.Object) (string, error) {
+	var pods corev1.PodList
+	selector := fmt.Sprintf("job-name=%s", job.GetName())
+	if err := cfg.Client().Resources(job.GetNamespace()).List(ctx, &pods, resources.WithLabelSelector(selector)); err != nil {
+		return "", fmt.Errorf("list job pods: %w", err)
+	}
+
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pod found for job %q", job.GetName())
+	}
+
+	return podLogs(ctx, cfg, job.GetNamespace(), pods.Items[0].Name)
+}
+
+// podLogs returns the logs of the container of a pod
+func podLogs(ctx context.Context, cfg *envconf.Config, namespace, pod string) (string, error) {
+	client, err := kubernetes.NewForConfig(cfg.Client().RESTConfig())
+	if err != nil {
+		return "", fmt.Errorf("create kubernetes client: %w", err)
+	}
+
+	logs, err := client.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get logs of pod %q: %w", pod, err)
+	}
+	return string(logs), nil
+}
+
+// followPodLogs streams the logs of the container of a pod until it terminates or ctx is done
+func followPodLogs(ctx context.Context, cfg *envconf.Config, namespace, pod string) (io.ReadCloser, error) {
+	client, err := kubernetes.NewForConfig(cfg.Client().RESTConfig())
+	if err != nil {
+		return nil, fmt.Errorf("create kubernetes client: %w", err)
+	}
+
+	logs, err := client.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Follow: true}).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("follow logs of pod %q: %w", pod, err)
+	}
+	return logs, nil
+}
+
+// portForward forwards a random local port to the given port of a pod. The returned function stops forwarding.
+func portForward(ctx context.Context, cfg *envconf.Config, namespace, pod string, port int) (int, func(), error) {
+	restCfg := cfg.Client().RESTConfig()
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create kubernetes client: %w", err)
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(restCfg)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create round tripper: %w", err)
+	}
+
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	ports := []string{fmt.Sprintf("0:%d", port)}
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, ports, stopCh, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create port forwarder: %w", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(stopCh) })
+	}
+
+	select {
+	case <-readyCh:
+	case err = <-errCh:
+		return 0, nil, fmt.Errorf("forward ports: %w", err)
+	case <-ctx.Done():
+		stop()
+		return 0, nil, ctx.Err()
+	}
+
+	forwarded, err := fw.GetPorts()
+	if err != nil {
+		stop()
+		return 0, nil, fmt.Errorf("get forwarded ports: %w", err)
+	}
+
+	if len(forwarded) != 1 {
+		stop()
+		return 0, nil, errors.New("unexpected number of forwarded ports")
+	}
+
+	klog.Infof("forwarding local port %d to port %d of pod %q in namespace %q", forwarded[0].Local, port, pod, namespace)
+	return int(forwarded[0].Local), stop, nil
+}
+
+// servicePortForward forwards a random local port to the given port of a service. Like kubectl, the connection goes
+// to the target port of a single ready endpoint of the service. The returned function stops forwarding.
+func servicePortForward(ctx context.Context, cfg *envconf.Config, namespace, service string, port int) (int, func(), error) {
+	var svc corev1.Service
+	if err := cfg.Client().Resources().Get(ctx, service, namespace, &svc); err != nil {
+		return 0, nil, fmt.Errorf("get service: %w", err)
+	}
+
+	var portName string
+	found := false
+	for _, p := range svc.Spec.Ports {
+		if int(p.Port) == port {
+			portName, found = p.Name, true
+			break
+		}
+	}
+	if !found {
+		return 0, nil, fmt.Errorf("service %q has no port %d", service, port)
+	}
+
+	var endpoints corev1.Endpoints
+	if err := cfg.Client().Resources().Get(ctx, service, namespace, &endpoints); err != nil {
+		return 0, nil, fmt.Errorf("get endpoints: %w", err)
+	}
+
+	// endpoint ports have the name of the service port and the resolved target port
+	for _, subset := range endpoints.Subsets {
+		for _, p := range subset.Ports {
+			if p.Name != portName {
+				continue
+			}
+
+			for _, addr := range subset.Addresses {
+				if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+					return portForward(ctx, cfg, namespace, addr.TargetRef.Name, int(p.Port))
+				}
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("no ready endpoint found for port %d of service %q", port, service)
+}
+
+// forwardPodPort forwards a random local port to the given port of a pod until the end of the feature step
+func forwardPodPort(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace, pod string, port int) int {
+	t.Helper()
+
+	local, stop, err := portForward(ctx, cfg, namespace, pod, port)
+	assert.NilError(t, err)
+	t.Cleanup(stop)
+
+	return local
+}
+
+// forwardServicePort forwards a random local port to the given port of a service until the end of the feature step
+func forwardServicePort(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace, service string, port int) int {
+	t.Helper()
+
+	local, stop, err := servicePortForward(ctx, cfg, namespace, service, port)
+	assert.NilError(t, err)
+	t.Cleanup(stop)
+
+	return local
+}
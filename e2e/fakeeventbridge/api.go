@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+const (
+	// X-Amz-Target header value prefix of EventBridge operations
+	targetPrefix = "AWSEvents."
+	jsonType     = "application/x-amz-json-1.1"
+)
+
+// apiError is returned to clients in the EventBridge JSON error format
+type apiError struct {
+	code    string
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func validationError(format string, args ...any) apiError {
+	return apiError{code: "ValidationException", message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundError(format string, args ...any) apiError {
+	return apiError{code: "ResourceNotFoundException", message: fmt.Sprintf(format, args...)}
+}
+
+type operation func(body []byte) (any, error)
+
+type api struct {
+	logger     *zap.Logger
+	store      *store
+	operations map[string]operation
+}
+
+func newAPI(logger *zap.Logger, s *store) *api {
+	a := api{
+		logger: logger,
+		store:  s,
+	}
+
+	a.operations = map[string]operation{
+		"CreateEventBus":      a.createEventBus,
+		"DescribeEventBus":    a.describeEventBus,
+		"ListEventBuses":      a.listEventBuses,
+		"DeleteEventBus":      a.deleteEventBus,
+		"TagResource":         a.tagResource,
+		"UntagResource":       a.untagResource,
+		"ListTagsForResource": a.listTagsForResource,
+		"PutRule":             a.putRule,
+		"DescribeRule":        a.describeRule,
+		"DeleteRule":          a.deleteRule,
+		"ListTargetsByRule":   a.listTargetsByRule,
+		"PutTargets":          a.putTargets,
+		"RemoveTargets":       a.removeTargets,
+	}
+
+	return &a
+}
+
+func (a *api) handle(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	target := r.Header.Get("X-Amz-Target")
+	if target == "" {
+		a.handleQuery(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(target, targetPrefix)
+	op, ok := a.operations[name]
+	if !ok {
+		a.writeError(w, name, apiError{code: "UnknownOperationException", message: fmt.Sprintf("unsupported operation %q", target)})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		a.writeError(w, name, validationError("could not read request body: %v", err))
+		return
+	}
+
+	resp, err := op(body)
+	if err != nil {
+		a.writeError(w, name, err)
+		return
+	}
+
+	a.logger.Info("handled request", zap.String("operation", name), zap.ByteString("request", body))
+	w.Header().Set("Content-Type", jsonType)
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("could not encode response", zap.String("operation", name), zap.Error(err))
+	}
+}
+
+func (a *api) writeError(w http.ResponseWriter, operation string, err error) {
+	var apiErr apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = apiError{code: "InternalException", message: err.Error()}
+	}
+
+	a.logger.Info("returning error", zap.String("operation", operation), zap.Error(apiErr))
+
+	status := http.StatusBadRequest
+	if apiErr.code == "InternalException" {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", jsonType)
+	w.Header().Set("X-Amzn-Errortype", apiErr.code)
+	w.WriteHeader(status)
+
+	resp := struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}{Type: apiErr.code, Message: apiErr.message}
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("could not encode error response", zap.Error(err))
+	}
+}
+
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"https://sts.amazonaws.com/doc/2011-06-15/ GetCallerIdentityResponse"`
+	Result  struct {
+		Arn     string `xml:"Arn"`
+		UserID  string `xml:"UserId"`
+		Account string `xml:"Account"`
+	} `xml:"GetCallerIdentityResult"`
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+// handleQuery serves the STS GetCallerIdentity operation which the ACK runtime uses to look up the account ID
+func (a *api) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := r.PostForm.Get("Action")
+	if action != "GetCallerIdentity" {
+		http.Error(w, fmt.Sprintf("unsupported action %q", action), http.StatusBadRequest)
+		return
+	}
+
+	var resp getCallerIdentityResponse
+	resp.Result.Arn = fmt.Sprintf("arn:aws:iam::%s:user/fake", a.store.accountID)
+	resp.Result.UserID = "FAKEUSERID"
+	resp.Result.Account = a.store.accountID
+	resp.RequestID = uuid.NewString()
+
+	a.logger.Info("handled request", zap.String("operation", action))
+	w.Header().Set("Content-Type", "text/xml")
+	if err := xml.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("could not encode response", zap.String("operation", action), zap.Error(err))
+	}
+}
+
+func decode(body []byte, v any) error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return validationError("could not decode request: %v", err)
+	}
+	return nil
+}
+
+func (a *api) createEventBus(body []byte) (any, error) {
+	var req struct {
+		Name string `json:"Name"`
+		Tags []tag  `json:"Tags"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	bus, err := a.store.createBus(req.Name, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		EventBusArn string `json:"EventBusArn"`
+	}{EventBusArn: bus.Arn}, nil
+}
+
+func (a *api) describeEventBus(body []byte) (any, error) {
+	var req struct {
+		Name string `json:"Name"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return a.store.describeBus(req.Name)
+}
+
+func (a *api) listEventBuses(body []byte) (any, error) {
+	var req struct {
+		NamePrefix string `json:"NamePrefix"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		EventBuses []eventBus `json:"EventBuses"`
+	}{EventBuses: a.store.listBuses(req.NamePrefix)}, nil
+}
+
+func (a *api) deleteEventBus(body []byte) (any, error) {
+	var req struct {
+		Name string `json:"Name"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return struct{}{}, a.store.deleteBus(req.Name)
+}
+
+func (a *api) tagResource(body []byte) (any, error) {
+	var req struct {
+		ResourceARN string `json:"ResourceARN"`
+		Tags        []tag  `json:"Tags"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return struct{}{}, a.store.tagResource(req.ResourceARN, req.Tags)
+}
+
+func (a *api) untagResource(body []byte) (any, error) {
+	var req struct {
+		ResourceARN string   `json:"ResourceARN"`
+		TagKeys     []string `json:"TagKeys"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return struct{}{}, a.store.untagResource(req.ResourceARN, req.TagKeys)
+}
+
+func (a *api) listTagsForResource(body []byte) (any, error) {
+	var req struct {
+		ResourceARN string `json:"ResourceARN"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	tags, err := a.store.listTags(req.ResourceARN)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Tags []tag `json:"Tags"`
+	}{Tags: tags}, nil
+}
+
+func (a *api) putRule(body []byte) (any, error) {
+	var req struct {
+		rule
+		Tags []tag `json:"Tags"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	r, err := a.store.putRule(req.rule, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		RuleArn string `json:"RuleArn"`
+	}{RuleArn: r.Arn}, nil
+}
+
+func (a *api) describeRule(body []byte) (any, error) {
+	var req struct {
+		Name         string `json:"Name"`
+		EventBusName string `json:"EventBusName"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return a.store.describeRule(req.EventBusName, req.Name)
+}
+
+func (a *api) deleteRule(body []byte) (any, error) {
+	var req struct {
+		Name         string `json:"Name"`
+		EventBusName string `json:"EventBusName"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return struct{}{}, a.store.deleteRule(req.EventBusName, req.Name)
+}
+
+func (a *api) listTargetsByRule(body []byte) (any, error) {
+	var req struct {
+		Rule         string `json:"Rule"`
+		EventBusName string `json:"EventBusName"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	targets, err := a.store.listTargets(req.EventBusName, req.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Targets []target `json:"Targets"`
+	}{Targets: targets}, nil
+}
+
+// failedEntries is the response of the target operations. The fake api applies all or no entries.
+type failedEntries struct {
+	FailedEntries    []any `json:"FailedEntries"`
+	FailedEntryCount int   `json:"FailedEntryCount"`
+}
+
+func (a *api) putTargets(body []byte) (any, error) {
+	var req struct {
+		Rule         string   `json:"Rule"`
+		EventBusName string   `json:"EventBusName"`
+		Targets      []target `json:"Targets"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return failedEntries{FailedEntries: []any{}}, a.store.putTargets(req.EventBusName, req.Rule, req.Targets)
+}
+
+func (a *api) removeTargets(body []byte) (any, error) {
+	var req struct {
+		Rule         string   `json:"Rule"`
+		EventBusName string   `json:"EventBusName"`
+		Ids          []string `json:"Ids"`
+	}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+
+	return failedEntries{FailedEntries: []any{}}, a.store.removeTargets(req.EventBusName, req.Rule, req.Ids)
+}
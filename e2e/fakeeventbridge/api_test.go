@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+	"gotest.tools/v3/assert"
+)
+
+const (
+	testRegion       = "eu-central-1"
+	testAccountID    = "123456789012"
+	testDeniedPrefix = "denied"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	a := newAPI(zap.NewNop(), newStore(testRegion, testAccountID, testDeniedPrefix))
+	router := httprouter.New()
+	router.POST("/", a.handle)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call invokes the given operation with the JSON 1.1 protocol and returns the status code and decoded response body
+func call(t *testing.T, srv *httptest.Server, operation, body string) (int, map[string]any) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	assert.NilError(t, err)
+	req.Header.Set("Content-Type", jsonType)
+	req.Header.Set("X-Amz-Target", targetPrefix+operation)
+
+	resp, err := srv.Client().Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, resp.Header.Get("Content-Type"), jsonType)
+
+	var out map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	assert.NilError(t, err)
+
+	if resp.StatusCode != http.StatusOK {
+		assert.Equal(t, resp.Header.Get("X-Amzn-Errortype"), out["__type"])
+	}
+	return resp.StatusCode, out
+}
+
+// mustCall invokes the given operation and asserts that it succeeded
+func mustCall(t *testing.T, srv *httptest.Server, operation, body string) map[string]any {
+	t.Helper()
+
+	status, out := call(t, srv, operation, body)
+	assert.Equal(t, status, http.StatusOK, "%s returned %v", operation, out)
+	return out
+}
+
+func TestEventBusOperations(t *testing.T) {
+	srv := newTestServer(t)
+	arn := "arn:aws:events:eu-central-1:123456789012:event-bus/e2e-bus"
+
+	out := mustCall(t, srv, "CreateEventBus", `{"Name":"e2e-bus","Tags":[{"Key":"team","Value":"e2e"}]}`)
+	assert.Equal(t, out["EventBusArn"], arn)
+
+	out = mustCall(t, srv, "DescribeEventBus", `{"Name":"e2e-bus"}`)
+	assert.Equal(t, out["Name"], "e2e-bus")
+	assert.Equal(t, out["Arn"], arn)
+
+	// arns are accepted in place of names
+	out = mustCall(t, srv, "DescribeEventBus", `{"Name":"`+arn+`"}`)
+	assert.Equal(t, out["Name"], "e2e-bus")
+
+	out = mustCall(t, srv, "ListEventBuses", `{}`)
+	assert.Equal(t, len(out["EventBuses"].([]any)), 2)
+	out = mustCall(t, srv, "ListEventBuses", `{"NamePrefix":"e2e"}`)
+	assert.Equal(t, len(out["EventBuses"].([]any)), 1)
+
+	out = mustCall(t, srv, "ListTagsForResource", `{"ResourceARN":"`+arn+`"}`)
+	assert.DeepEqual(t, out["Tags"], []any{map[string]any{"Key": "team", "Value": "e2e"}})
+
+	mustCall(t, srv, "DeleteEventBus", `{"Name":"e2e-bus"}`)
+	// deleting a non-existing bus is not an error
+	mustCall(t, srv, "DeleteEventBus", `{"Name":"e2e-bus"}`)
+
+	status, out := call(t, srv, "DescribeEventBus", `{"Name":"e2e-bus"}`)
+	assert.Equal(t, status, http.StatusBadRequest)
+	assert.Equal(t, out["__type"], "ResourceNotFoundException")
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup operations which must succeed, pairs of operation and body
+		setup     [][2]string
+		operation string
+		body      string
+		code      string
+		message   string
+	}{
+		{
+			name:      "unknown operation",
+			operation: "PutEvents",
+			body:      `{}`,
+			code:      "UnknownOperationException",
+		},
+		{
+			name:      "invalid request",
+			operation: "CreateEventBus",
+			body:      `{"Name":`,
+			code:      "ValidationException",
+			message:   "could not decode request",
+		},
+		{
+			name:      "invalid bus name",
+			operation: "CreateEventBus",
+			body:      `{"Name":"e2e bus!"}`,
+			code:      "ValidationException",
+		},
+		{
+			name:      "bus exists",
+			setup:     [][2]string{{"CreateEventBus", `{"Name":"e2e-bus"}`}},
+			operation: "CreateEventBus",
+			body:      `{"Name":"e2e-bus"}`,
+			code:      "ResourceAlreadyExistsException",
+			message:   "Event bus e2e-bus already exists.",
+		},
+		{
+			name:      "create denied bus",
+			operation: "CreateEventBus",
+			body:      `{"Name":"denied-bus"}`,
+			code:      "AccessDeniedException",
+			message:   "events:CreateEventBus",
+		},
+		{
+			name:      "describe denied bus",
+			operation: "DescribeEventBus",
+			body:      `{"Name":"denied-bus"}`,
+			code:      "AccessDeniedException",
+			message:   "events:DescribeEventBus",
+		},
+		{
+			name:      "delete denied bus",
+			operation: "DeleteEventBus",
+			body:      `{"Name":"denied-bus"}`,
+			code:      "AccessDeniedException",
+			message:   "events:DeleteEventBus on resource: arn:aws:events:eu-central-1:123456789012:event-bus/denied-bus",
+		},
+		{
+			name:      "delete default bus",
+			operation: "DeleteEventBus",
+			body:      `{"Name":"default"}`,
+			code:      "ValidationException",
+		},
+		{
+			name: "delete bus with rules",
+			setup: [][2]string{
+				{"CreateEventBus", `{"Name":"e2e-bus"}`},
+				{"PutRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus","EventPattern":"{\"source\":[\"e2e\"]}"}`},
+			},
+			operation: "DeleteEventBus",
+			body:      `{"Name":"e2e-bus"}`,
+			code:      "ValidationException",
+			message:   "Cannot delete event bus e2e-bus because it has rules.",
+		},
+		{
+			name:      "rule on missing bus",
+			operation: "PutRule",
+			body:      `{"Name":"e2e-rule","EventBusName":"missing","EventPattern":"{}"}`,
+			code:      "ResourceNotFoundException",
+		},
+		{
+			name:      "rule without pattern",
+			operation: "PutRule",
+			body:      `{"Name":"e2e-rule"}`,
+			code:      "ValidationException",
+			message:   "EventPattern or ScheduleExpression must be specified",
+		},
+		{
+			name:      "invalid event pattern",
+			operation: "PutRule",
+			body:      `{"Name":"e2e-rule","EventPattern":"{\"source\":"}`,
+			code:      "InvalidEventPatternException",
+		},
+		{
+			name: "delete rule with targets",
+			setup: [][2]string{
+				{"PutRule", `{"Name":"e2e-rule","EventPattern":"{}"}`},
+				{"PutTargets", `{"Rule":"e2e-rule","Targets":[{"Id":"1","Arn":"arn:aws:sqs:eu-central-1:123456789012:e2e"}]}`},
+			},
+			operation: "DeleteRule",
+			body:      `{"Name":"e2e-rule"}`,
+			code:      "ValidationException",
+			message:   "Rule can't be deleted since it has targets.",
+		},
+		{
+			name:      "target without arn",
+			setup:     [][2]string{{"PutRule", `{"Name":"e2e-rule","EventPattern":"{}"}`}},
+			operation: "PutTargets",
+			body:      `{"Rule":"e2e-rule","Targets":[{"Id":"1"}]}`,
+			code:      "ValidationException",
+		},
+		{
+			name:      "tags of missing resource",
+			operation: "ListTagsForResource",
+			body:      `{"ResourceARN":"arn:aws:events:eu-central-1:123456789012:event-bus/missing"}`,
+			code:      "ResourceNotFoundException",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			for _, op := range tt.setup {
+				mustCall(t, srv, op[0], op[1])
+			}
+
+			status, out := call(t, srv, tt.operation, tt.body)
+			assert.Equal(t, status, http.StatusBadRequest)
+			assert.Equal(t, out["__type"], tt.code)
+			assert.Assert(t, strings.Contains(out["message"].(string), tt.message), "unexpected message %q", out["message"])
+		})
+	}
+}
+
+func TestDeleteBusAfterRules(t *testing.T) {
+	srv := newTestServer(t)
+
+	mustCall(t, srv, "CreateEventBus", `{"Name":"e2e-bus"}`)
+	mustCall(t, srv, "PutRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus","EventPattern":"{}"}`)
+
+	status, _ := call(t, srv, "DeleteEventBus", `{"Name":"e2e-bus"}`)
+	assert.Equal(t, status, http.StatusBadRequest)
+
+	mustCall(t, srv, "DeleteRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus"}`)
+	mustCall(t, srv, "DeleteEventBus", `{"Name":"e2e-bus"}`)
+
+	out := mustCall(t, srv, "ListEventBuses", `{"NamePrefix":"e2e"}`)
+	assert.Equal(t, len(out["EventBuses"].([]any)), 0)
+}
+
+func TestRuleOperations(t *testing.T) {
+	srv := newTestServer(t)
+	arn := "arn:aws:events:eu-central-1:123456789012:rule/e2e-bus/e2e-rule"
+
+	mustCall(t, srv, "CreateEventBus", `{"Name":"e2e-bus"}`)
+	out := mustCall(t, srv, "PutRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus","EventPattern":"{}","Tags":[{"Key":"a","Value":"1"}]}`)
+	assert.Equal(t, out["RuleArn"], arn)
+
+	out = mustCall(t, srv, "DescribeRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus"}`)
+	assert.Equal(t, out["State"], "ENABLED")
+	assert.Equal(t, out["EventPattern"], "{}")
+
+	// updates replace the configuration, tags are only applied on creation
+	mustCall(t, srv, "PutRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus","EventPattern":"{}","State":"DISABLED","Tags":[{"Key":"b","Value":"2"}]}`)
+	out = mustCall(t, srv, "DescribeRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus"}`)
+	assert.Equal(t, out["State"], "DISABLED")
+
+	mustCall(t, srv, "TagResource", `{"ResourceARN":"`+arn+`","Tags":[{"Key":"c","Value":"3"}]}`)
+	mustCall(t, srv, "UntagResource", `{"ResourceARN":"`+arn+`","TagKeys":["a"]}`)
+	out = mustCall(t, srv, "ListTagsForResource", `{"ResourceARN":"`+arn+`"}`)
+	assert.DeepEqual(t, out["Tags"], []any{map[string]any{"Key": "c", "Value": "3"}})
+
+	targets := `{"Rule":"e2e-rule","EventBusName":"e2e-bus","Targets":[` +
+		`{"Id":"1","Arn":"arn:aws:sqs:eu-central-1:123456789012:one"},` +
+		`{"Id":"2","Arn":"arn:aws:sqs:eu-central-1:123456789012:two","Input":"{}"}]}`
+	out = mustCall(t, srv, "PutTargets", targets)
+	assert.Equal(t, out["FailedEntryCount"], float64(0))
+
+	// targets with an existing id are replaced
+	mustCall(t, srv, "PutTargets", `{"Rule":"e2e-rule","EventBusName":"e2e-bus","Targets":[{"Id":"1","Arn":"arn:aws:sqs:eu-central-1:123456789012:new"}]}`)
+	out = mustCall(t, srv, "ListTargetsByRule", `{"Rule":"e2e-rule","EventBusName":"e2e-bus"}`)
+	assert.DeepEqual(t, out["Targets"], []any{
+		map[string]any{"Id": "1", "Arn": "arn:aws:sqs:eu-central-1:123456789012:new"},
+		map[string]any{"Id": "2", "Arn": "arn:aws:sqs:eu-central-1:123456789012:two", "Input": "{}"},
+	})
+
+	mustCall(t, srv, "RemoveTargets", `{"Rule":"e2e-rule","EventBusName":"e2e-bus","Ids":["1","2"]}`)
+	mustCall(t, srv, "DeleteRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus"}`)
+
+	status, out := call(t, srv, "DescribeRule", `{"Name":"e2e-rule","EventBusName":"e2e-bus"}`)
+	assert.Equal(t, status, http.StatusBadRequest)
+	assert.Equal(t, out["__type"], "ResourceNotFoundException")
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := srv.Client().PostForm(srv.URL, url.Values{"Action": {"GetCallerIdentity"}, "Version": {"2011-06-15"}})
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Assert(t, strings.Contains(string(body), "<Account>123456789012</Account>"), "unexpected response %s", body)
+}
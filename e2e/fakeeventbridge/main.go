@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kelseyhightower/envconfig"
+	"go.uber.org/automaxprocs/maxprocs"
+	"go.uber.org/zap"
+)
+
+type loggerCtxKey string
+
+const (
+	loggerKey loggerCtxKey = "logger"
+)
+
+type config struct {
+	Address     string `envconfig:"ADDRESS" default:":4010"`
+	HealthZPath string `envconfig:"HEALTHZ_PATH" default:"/healthz"`
+	Region      string `envconfig:"AWS_REGION" default:"us-east-1"`
+	AccountID   string `envconfig:"AWS_ACCOUNT_ID" default:"000000000000"`
+	// DeniedPrefix simulates missing permissions for event buses with this name prefix
+	DeniedPrefix string `envconfig:"ACCESS_DENIED_PREFIX"`
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic("could not create logger: " + err.Error())
+	}
+	logger = logger.Named("fakeeventbridge")
+	ctx = context.WithValue(ctx, loggerKey, logger)
+
+	_, err = maxprocs.Set()
+	if err != nil {
+		logger.Fatal("could not set maxprocs goroutine limit", zap.Error(err))
+	}
+
+	var cfg config
+	err = envconfig.Process("", &cfg)
+	if err != nil {
+		logger.Fatal("could not create configuration", zap.Error(err))
+	}
+
+	if err = run(ctx, cfg); err != nil && !(errors.Is(err, context.Canceled) || errors.Is(err, http.ErrServerClosed)) {
+		logger.Fatal("could not run fake eventbridge", zap.Error(err))
+	}
+	logger.Info("shutdown complete")
+}
+
+// run serves a fake of the EventBridge (JSON 1.1 protocol) and STS GetCallerIdentity (query protocol) APIs, backed by
+// an in-memory store
+func run(ctx context.Context, cfg config) error {
+	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	api := newAPI(logger, newStore(cfg.Region, cfg.AccountID, cfg.DeniedPrefix))
+
+	router := httprouter.New()
+	router.POST("/", api.handle)
+	router.GET(cfg.HealthZPath, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := http.Server{
+		Addr:         cfg.Address,
+		Handler:      router,
+		ReadTimeout:  time.Second * 5,
+		WriteTimeout: time.Second * 5,
+	}
+
+	go func() {
+		<-ctx.Done()
+		logger.Info("shutting down http server", zap.Any("cause", ctx.Err()))
+
+		timeoutCtx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+
+		if err := srv.Shutdown(timeoutCtx); err != nil {
+			logger.Error("could not shut down http server", zap.Error(err))
+		}
+	}()
+
+	logger.Info(
+		"starting fake eventbridge api",
+		zap.String("address", cfg.Address),
+		zap.String("region", cfg.Region),
+		zap.String("accountID", cfg.AccountID),
+	)
+	return srv.ListenAndServe()
+}
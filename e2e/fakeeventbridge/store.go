@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultBus = "default"
+
+var (
+	busNameRegex  = regexp.MustCompile(`^[/\.\-_A-Za-z0-9]{1,256}$`)
+	ruleNameRegex = regexp.MustCompile(`^[\.\-_A-Za-z0-9]{1,64}$`)
+)
+
+type eventBus struct {
+	Arn    string `json:"Arn"`
+	Name   string `json:"Name"`
+	Policy string `json:"Policy,omitempty"`
+}
+
+type rule struct {
+	Arn                string `json:"Arn"`
+	Name               string `json:"Name"`
+	EventBusName       string `json:"EventBusName"`
+	Description        string `json:"Description,omitempty"`
+	EventPattern       string `json:"EventPattern,omitempty"`
+	ScheduleExpression string `json:"ScheduleExpression,omitempty"`
+	RoleArn            string `json:"RoleArn,omitempty"`
+	State              string `json:"State"`
+}
+
+// target is kept as sent by the client so that all target fields round-trip unchanged
+type target map[string]any
+
+func (t target) id() string {
+	id, _ := t["Id"].(string)
+	return id
+}
+
+type tag struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+// store holds the state of the fake api. All methods are safe for concurrent use.
+type store struct {
+	sync.Mutex
+	region    string
+	accountID string
+	// operations on event buses with this name prefix are denied
+	deniedPrefix string
+
+	buses map[string]eventBus
+	// rules and targets by rule key, see ruleKey
+	rules   map[string]rule
+	targets map[string][]target
+	// tags by resource arn
+	tags map[string]map[string]string
+}
+
+func newStore(region, accountID, deniedPrefix string) *store {
+	s := store{
+		region:       region,
+		accountID:    accountID,
+		deniedPrefix: deniedPrefix,
+		buses:        make(map[string]eventBus),
+		rules:        make(map[string]rule),
+		targets:      make(map[string][]target),
+		tags:         make(map[string]map[string]string),
+	}
+
+	s.buses[defaultBus] = eventBus{Arn: s.busArn(defaultBus), Name: defaultBus}
+	return &s
+}
+
+func (s *store) busArn(name string) string {
+	return fmt.Sprintf("arn:aws:events:%s:%s:event-bus/%s", s.region, s.accountID, name)
+}
+
+func (s *store) ruleArn(bus, name string) string {
+	if bus == defaultBus {
+		return fmt.Sprintf("arn:aws:events:%s:%s:rule/%s", s.region, s.accountID, name)
+	}
+	return fmt.Sprintf("arn:aws:events:%s:%s:rule/%s/%s", s.region, s.accountID, bus, name)
+}
+
+// busName returns the bus name of the given name or arn and defaults to the default bus
+func busName(nameOrArn string) string {
+	if nameOrArn == "" {
+		return defaultBus
+	}
+
+	if i := strings.Index(nameOrArn, ":event-bus/"); strings.HasPrefix(nameOrArn, "arn:") && i >= 0 {
+		return nameOrArn[i+len(":event-bus/"):]
+	}
+	return nameOrArn
+}
+
+// authorize returns an AccessDeniedException like the real api for event buses with the denied name prefix
+func (s *store) authorize(action, bus string) error {
+	if s.deniedPrefix == "" || !strings.HasPrefix(bus, s.deniedPrefix) {
+		return nil
+	}
+
+	return apiError{
+		code: "AccessDeniedException",
+		message: fmt.Sprintf("User: arn:aws:iam::%s:user/fake is not authorized to perform: events:%s on resource: %s",
+			s.accountID, action, s.busArn(bus)),
+	}
+}
+
+func ruleKey(bus, name string) string {
+	return bus + "|" + name
+}
+
+func (s *store) createBus(name string, tags []tag) (eventBus, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !busNameRegex.MatchString(name) {
+		return eventBus{}, validationError("event bus name %q does not match %s", name, busNameRegex)
+	}
+
+	if err := s.authorize("CreateEventBus", name); err != nil {
+		return eventBus{}, err
+	}
+
+	if _, ok := s.buses[name]; ok {
+		return eventBus{}, apiError{code: "ResourceAlreadyExistsException", message: fmt.Sprintf("Event bus %s already exists.", name)}
+	}
+
+	bus := eventBus{Arn: s.busArn(name), Name: name}
+	s.buses[name] = bus
+	s.setTags(bus.Arn, tags)
+
+	return bus, nil
+}
+
+func (s *store) describeBus(name string) (eventBus, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	name = busName(name)
+	if !busNameRegex.MatchString(name) {
+		return eventBus{}, validationError("event bus name %q does not match %s", name, busNameRegex)
+	}
+
+	if err := s.authorize("DescribeEventBus", name); err != nil {
+		return eventBus{}, err
+	}
+
+	bus, ok := s.buses[name]
+	if !ok {
+		return eventBus{}, notFoundError("Event bus %s does not exist.", name)
+	}
+	return bus, nil
+}
+
+// listBuses returns all buses with the given name prefix sorted by name
+func (s *store) listBuses(prefix string) []eventBus {
+	s.Lock()
+	defer s.Unlock()
+
+	buses := make([]eventBus, 0, len(s.buses))
+	for name, bus := range s.buses {
+		if strings.HasPrefix(name, prefix) {
+			buses = append(buses, bus)
+		}
+	}
+
+	sort.Slice(buses, func(i, j int) bool {
+		return buses[i].Name < buses[j].Name
+	})
+	return buses
+}
+
+// deleteBus deletes the given bus. Like the real api, a bus with rules cannot be deleted and deleting a non-existing
+// bus is not an error.
+func (s *store) deleteBus(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	name = busName(name)
+	if name == defaultBus {
+		return validationError("Cannot delete event bus %s.", name)
+	}
+
+	if err := s.authorize("DeleteEventBus", name); err != nil {
+		return err
+	}
+
+	for _, r := range s.rules {
+		if r.EventBusName == name {
+			return validationError("Cannot delete event bus %s because it has rules.", name)
+		}
+	}
+
+	if bus, ok := s.buses[name]; ok {
+		delete(s.tags, bus.Arn)
+		delete(s.buses, name)
+	}
+	return nil
+}
+
+// putRule creates the given rule or, if it exists, replaces its configuration. Tags are only applied on creation.
+func (s *store) putRule(r rule, tags []tag) (rule, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	r.EventBusName = busName(r.EventBusName)
+	if _, ok := s.buses[r.EventBusName]; !ok {
+		return rule{}, notFoundError("Event bus %s does not exist.", r.EventBusName)
+	}
+
+	if !ruleNameRegex.MatchString(r.Name) {
+		return rule{}, validationError("rule name %q does not match %s", r.Name, ruleNameRegex)
+	}
+
+	if r.EventPattern == "" && r.ScheduleExpression == "" {
+		return rule{}, validationError("Parameter(s) EventPattern or ScheduleExpression must be specified.")
+	}
+
+	if r.EventPattern != "" {
+		var pattern map[string]any
+		if err := json.Unmarshal([]byte(r.EventPattern), &pattern); err != nil {
+			return rule{}, apiError{code: "InvalidEventPatternException", message: fmt.Sprintf("Event pattern is not valid. Reason: %v", err)}
+		}
+	}
+
+	switch r.State {
+	case "":
+		r.State = "ENABLED"
+	case "ENABLED", "DISABLED":
+	default:
+		return rule{}, validationError("state %q is not supported", r.State)
+	}
+
+	key := ruleKey(r.EventBusName, r.Name)
+	r.Arn = s.ruleArn(r.EventBusName, r.Name)
+	if _, ok := s.rules[key]; !ok {
+		s.setTags(r.Arn, tags)
+	}
+	s.rules[key] = r
+
+	return r, nil
+}
+
+func (s *store) describeRule(bus, name string) (rule, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	bus = busName(bus)
+	r, ok := s.rules[ruleKey(bus, name)]
+	if !ok {
+		return rule{}, notFoundError("Rule %s does not exist on EventBus %s.", name, bus)
+	}
+	return r, nil
+}
+
+// deleteRule deletes the given rule. Like the real api, a rule with targets cannot be deleted and deleting a
+// non-existing rule is not an error.
+func (s *store) deleteRule(bus, name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key := ruleKey(busName(bus), name)
+	r, ok := s.rules[key]
+	if !ok {
+		return nil
+	}
+
+	if len(s.targets[key]) > 0 {
+		return validationError("Rule can't be deleted since it has targets.")
+	}
+
+	delete(s.tags, r.Arn)
+	delete(s.rules, key)
+	return nil
+}
+
+// listTargets returns the targets of the given rule in the order they were added
+func (s *store) listTargets(bus, name string) ([]target, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	bus = busName(bus)
+	key := ruleKey(bus, name)
+	if _, ok := s.rules[key]; !ok {
+		return nil, notFoundError("Rule %s does not exist on EventBus %s.", name, bus)
+	}
+
+	return append([]target{}, s.targets[key]...), nil
+}
+
+// putTargets adds the given targets to a rule, replacing existing targets with the same id
+func (s *store) putTargets(bus, name string, targets []target) error {
+	s.Lock()
+	defer s.Unlock()
+
+	bus = busName(bus)
+	key := ruleKey(bus, name)
+	if _, ok := s.rules[key]; !ok {
+		return notFoundError("Rule %s does not exist on EventBus %s.", name, bus)
+	}
+
+	for _, t := range targets {
+		if t.id() == "" {
+			return validationError("target id must be specified")
+		}
+		if arn, _ := t["Arn"].(string); arn == "" {
+			return validationError("target arn must be specified")
+		}
+	}
+
+outer:
+	for _, t := range targets {
+		for i, existing := range s.targets[key] {
+			if existing.id() == t.id() {
+				s.targets[key][i] = t
+				continue outer
+			}
+		}
+		s.targets[key] = append(s.targets[key], t)
+	}
+
+	return nil
+}
+
+func (s *store) removeTargets(bus, name string, ids []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	bus = busName(bus)
+	key := ruleKey(bus, name)
+	if _, ok := s.rules[key]; !ok {
+		return notFoundError("Rule %s does not exist on EventBus %s.", name, bus)
+	}
+
+	remaining := s.targets[key][:0]
+	for _, t := range s.targets[key] {
+		removed := false
+		for _, id := range ids {
+			if t.id() == id {
+				removed = true
+				break
+			}
+		}
+
+		if !removed {
+			remaining = append(remaining, t)
+		}
+	}
+	s.targets[key] = remaining
+
+	return nil
+}
+
+func (s *store) tagResource(arn string, tags []tag) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exists(arn) {
+		return notFoundError("Resource %s does not exist.", arn)
+	}
+
+	s.setTags(arn, tags)
+	return nil
+}
+
+func (s *store) untagResource(arn string, keys []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exists(arn) {
+		return notFoundError("Resource %s does not exist.", arn)
+	}
+
+	for _, k := range keys {
+		delete(s.tags[arn], k)
+	}
+	return nil
+}
+
+// listTags returns the tags of the given resource sorted by key
+func (s *store) listTags(arn string) ([]tag, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exists(arn) {
+		return nil, notFoundError("Resource %s does not exist.", arn)
+	}
+
+	tags := make([]tag, 0, len(s.tags[arn]))
+	for k, v := range s.tags[arn] {
+		tags = append(tags, tag{Key: k, Value: v})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Key < tags[j].Key
+	})
+	return tags, nil
+}
+
+// exists must be called with the lock held
+func (s *store) exists(arn string) bool {
+	for _, bus := range s.buses {
+		if bus.Arn == arn {
+			return true
+		}
+	}
+
+	for _, r := range s.rules {
+		if r.Arn == arn {
+			return true
+		}
+	}
+	return false
+}
+
+// setTags must be called with the lock held
+func (s *store) setTags(arn string, tags []tag) {
+	if len(tags) == 0 {
+		return
+	}
+
+	if s.tags[arn] == nil {
+		s.tags[arn] = make(map[string]string)
+	}
+
+	for _, t := range tags {
+		s.tags[arn][t.Key] = t.Value
+	}
+}
@@ -2,21 +2,38 @@ package e2e
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	ebv1alpha "github.com/aws-controllers-k8s/eventbridge-controller/apis/v1alpha1"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	ecrsvcsdk "github.com/aws/aws-sdk-go/service/ecrpublic"
 	ebsvcsdk "github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/nats-io/nats.go"
 	"gotest.tools/v3/assert"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
 )
 
@@ -43,7 +60,7 @@ func createNSForFeature(ctx context.Context, cfg *envconf.Config, feature string
 	return ctx, cfg.Client().Resources().Create(ctx, &nsObj)
 }
 
-// deleteNSForFeature looks up the namespace corresponding to the given test and deletes it.
+// deleteNSForFeature looks up the namespace corresponding to the given test, deletes it and waits until it is gone.
 func deleteNSForFeature(ctx context.Context, cfg *envconf.Config, t *testing.T, feature string) (context.Context, error) {
 	ns := getTestNamespaceFromContext(ctx, t)
 
@@ -52,7 +69,19 @@ func deleteNSForFeature(ctx context.Context, cfg *envconf.Config, t *testing.T,
 	nsObj := corev1.Namespace{}
 	nsObj.Name = ns
 
-	return ctx, cfg.Client().Resources().Delete(ctx, &nsObj)
+	if err := cfg.Client().Resources().Delete(ctx, &nsObj); err != nil {
+		return ctx, err
+	}
+
+	// finalizers, e.g. of ack resources, may keep the namespace terminating
+	klog.Infof("waiting for namespace %q to terminate", ns)
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	err := wait.For(conditions.New(cfg.Client().Resources()).ResourceDeleted(&nsObj), wait.WithContext(waitCtx))
+	if err != nil {
+		return ctx, fmt.Errorf("wait for namespace %q to terminate: %w", ns, err)
+	}
+	return ctx, nil
 }
 
 func getTestNamespaceFromContext(ctx context.Context, t *testing.T) string {
@@ -61,53 +90,291 @@ func getTestNamespaceFromContext(ctx context.Context, t *testing.T) string {
 	return ns
 }
 
-func newDeployment(namespace string, name string, replicas int32, image string) v1.Deployment {
+func copyMap(in map[string]string) map[string]string {
+	out := make(map[string]string)
+
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func eventBusFor(name, namespace string, tags ...*ebv1alpha.Tag) ebv1alpha.EventBus {
+	return ebv1alpha.EventBus{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: ebv1alpha.EventBusSpec{
+			Name: aws.String(name),
+			Tags: tags,
+		},
+	}
+}
+
+// tagsFor converts tags to ack tags sorted by key
+func tagsFor(tags map[string]string) []*ebv1alpha.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ackTags := make([]*ebv1alpha.Tag, 0, len(tags))
+	for _, k := range keys {
+		ackTags = append(ackTags, &ebv1alpha.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return ackTags
+}
+
+// ruleFor returns a rule for the given event bus. An empty pattern is omitted.
+func ruleFor(name, namespace, bus, pattern string, targets ...*ebv1alpha.Target) ebv1alpha.Rule {
+	rule := ebv1alpha.Rule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: ebv1alpha.RuleSpec{
+			Name:         aws.String(name),
+			EventBusName: aws.String(bus),
+			Targets:      targets,
+		},
+	}
+
+	if pattern != "" {
+		rule.Spec.EventPattern = aws.String(pattern)
+	}
+
+	return rule
+}
+
+// equalJSON returns true if a and b are semantically equal JSON documents, e.g. ignoring whitespace
+func equalJSON(a, b string) bool {
+	var va, vb any
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+func assertEqualJSON(t *testing.T, got, want string) {
+	t.Helper()
+	assert.Assert(t, equalJSON(got, want), "json documents not equal: got %s, want %s", got, want)
+}
+
+func ecrSDKClient(ctx context.Context, t *testing.T) *ecrsvcsdk.ECRPublic {
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"), // https://docs.aws.amazon.com/general/latest/gr/ecr-public.html
+		Credentials: getAWSConfigFromContext(ctx, t).credentials(),
+	})
+	assert.NilError(t, err, "create ecr service client")
+
+	return ecrsvcsdk.New(s)
+}
+
+// ebSDKClient returns an eventbridge client for the aws service control plane or, if the feature runs against the fake
+// eventbridge, for the fake endpoint stored in the context
+func ebSDKClient(ctx context.Context, t *testing.T) *ebsvcsdk.EventBridge {
+	awscfg := getAWSConfigFromContext(ctx, t)
+	cfg := aws.Config{
+		Region:      aws.String(awscfg.Region),
+		Credentials: awscfg.credentials(),
+	}
+
+	if endpoint, ok := ctx.Value(fakeEndpointCtxKey).(string); ok {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+
+	s, err := session.NewSession(&cfg)
+	assert.NilError(t, err, "create eventbridge service client")
+
+	return ebsvcsdk.New(s)
+}
+
+// fakeEventBridgeFor returns the deployment and service of the fake eventbridge api
+func fakeEventBridgeFor(namespace, image string) (v1.Deployment, corev1.Service) {
+	deployment := newDeployment(namespace, fakeEventBridgeName, image,
+		withEnv("ADDRESS", fmt.Sprintf(":%d", fakeEventBridgePort)),
+		withEnv("AWS_REGION", fakeRegion),
+		withEnv("ACCESS_DENIED_PREFIX", deniedBusPrefix),
+		withPort("http", fakeEventBridgePort),
+		withReadinessProbe(httpProbe("/healthz", fakeEventBridgePort)),
+	)
+	labels := deployment.Spec.Selector.MatchLabels
+
+	service := corev1.Service{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      fakeEventBridgeName,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{
+					Port:       fakeEventBridgePort,
+					TargetPort: intstr.FromInt(fakeEventBridgePort),
+				},
+			},
+		},
+	}
+
+	return deployment, service
+}
+
+// helmRegistryConfig creates a temporary helm registry config, so that neither the helm nor the docker credentials of
+// the user are used or modified. With an empty username the config has no credentials and charts are pulled
+// anonymously. The returned function removes the config.
+func helmRegistryConfig(ctx context.Context, registry, username, password string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "e2e-helm-registry")
+	if err != nil {
+		return "", nil, fmt.Errorf("create registry config directory: %w", err)
+	}
+
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			klog.Errorf("could not remove helm registry config directory %q: %v", dir, err)
+		}
+	}
+
+	config := filepath.Join(dir, "config.json")
+	if username == "" {
+		if err = os.WriteFile(config, []byte("{}"), 0o600); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("write registry config: %w", err)
+		}
+		return config, cleanup, nil
+	}
+
+	klog.Infof("logging in to helm registry %q", registry)
+	cmd := exec.CommandContext(ctx, "helm", "registry", "login", registry,
+		"--registry-config", config,
+		"--username", username,
+		"--password-stdin",
+	)
+	cmd.Stdin = strings.NewReader(password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("helm registry login: %w: %s", err, out)
+	}
+
+	return config, cleanup, nil
+}
+
+// natsConfigFor returns the configuration of the nats servers created by natsManifestsFor. A single server is
+// equivalent to the chart configuration in natsConfig. Multiple servers form a jetstream cluster using the pod dns
+// names of the headless service.
+func natsConfigFor(namespace string, replicas int32) string {
+	conf := `
+server_name: $POD_NAME
+port: 4222
+http: 8222
+lame_duck_duration: 30s
+
+jetstream {
+  max_memory_store: 1Gi
+  max_file_store: 1Gi
+  store_dir: /data
+}
+`
+	if replicas == 1 {
+		return conf
+	}
+
+	routes := make([]string, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		routes = append(routes, fmt.Sprintf("    nats://%s-%d.%s.%s.svc.cluster.local:6222", natsDeployment, i, natsHeadless, namespace))
+	}
+
+	// clients only use the configured urls, e.g. the service or port-forwards, not the pod ips
+	return conf + fmt.Sprintf(`
+cluster {
+  name: %s
+  port: 6222
+  no_advertise: true
+  routes: [
+%s
+  ]
+}
+`, natsDeployment, strings.Join(routes, "\n"))
+}
+
+// natsManifestsFor returns the objects of a nats server with jetstream enabled, or a jetstream cluster if replicas is
+// greater than one. Jetstream files are stored on persistent volumes if persistent is set, otherwise they are lost
+// with the pod. The servers are a stateful set named natsDeployment and clients connect through a service with the
+// same name.
+func natsManifestsFor(namespace string, replicas int32, persistent bool) []k8s.Object {
 	labels := copyMap(commonLabels)
-	labels["app"] = "name"
+	labels["app"] = natsDeployment
 
-	env := []corev1.EnvVar{
-		{
-			Name:  "NATS_SERVER",
-			Value: fmt.Sprintf("%s.%s.svc.cluster.local", natsDeployment, namespace),
+	configMap := corev1.ConfigMap{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      natsDeployment,
+			Namespace: namespace,
+			Labels:    labels,
 		},
-		{
-			Name:  "NATS_TOPIC",
-			Value: natsStream,
+		Data: map[string]string{
+			"nats.conf": natsConfigFor(namespace, replicas),
 		},
-		{
-			Name:  "HEALTHZ_ADDRESS",
-			Value: ":8080",
+	}
+
+	service := corev1.Service{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      natsDeployment,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{Name: "client", Port: 4222, TargetPort: intstr.FromInt(4222)},
+				{Name: "monitor", Port: 8222, TargetPort: intstr.FromInt(8222)},
+			},
 		},
-		{
-			Name:  "HEALTHZ_PATH",
-			Value: "/healthz",
+	}
+
+	// pod dns names for cluster routes, resolvable before the servers are ready
+	headless := corev1.Service{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      natsHeadless,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: true,
+			Selector:                 labels,
+			Ports: []corev1.ServicePort{
+				{Name: "cluster", Port: 6222, TargetPort: intstr.FromInt(6222)},
+			},
 		},
 	}
 
 	health := corev1.Probe{
 		ProbeHandler: corev1.ProbeHandler{
 			HTTPGet: &corev1.HTTPGetAction{
-				Path: "/healthz",
-				Port: intstr.IntOrString{
-					IntVal: 8080,
-				},
+				Path: "/healthz?js-enabled-only=true",
+				Port: intstr.FromInt(8222),
 			},
 		},
-		InitialDelaySeconds: 1,
-		TimeoutSeconds:      3,
-		PeriodSeconds:       1,
-		SuccessThreshold:    1,
-		FailureThreshold:    1,
+		PeriodSeconds: 1,
 	}
 
-	return v1.Deployment{
+	statefulSet := v1.StatefulSet{
 		ObjectMeta: v12.ObjectMeta{
-			Name:      name,
+			Name:      natsDeployment,
 			Namespace: namespace,
 			Labels:    labels,
 		},
-		Spec: v1.DeploymentSpec{
-			Replicas: &replicas,
+		Spec: v1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: natsHeadless,
+			// all servers must be running to elect a jetstream meta leader
+			PodManagementPolicy: v1.ParallelPodManagement,
 			Selector: &v12.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -118,55 +385,194 @@ func newDeployment(namespace string, name string, replicas int32, image string)
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:            "publisher",
-							Image:           image,
+							Name:            natsContainer,
+							Image:           natsImage,
 							ImagePullPolicy: corev1.PullIfNotPresent,
-							Env:             env,
-							ReadinessProbe:  &health,
+							Args:            []string{"--config", "/etc/nats/nats.conf"},
+							Env: []corev1.EnvVar{
+								{
+									Name: "POD_NAME",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+									},
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{Name: "client", ContainerPort: 4222},
+								{Name: "cluster", ContainerPort: 6222},
+								{Name: "monitor", ContainerPort: 8222},
+							},
+							ReadinessProbe: &health,
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "config", MountPath: "/etc/nats"},
+								{Name: "data", MountPath: "/data"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: natsDeployment},
+								},
+							},
 						},
 					},
 				},
 			},
 		},
 	}
+
+	if !persistent {
+		statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name:         "data",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	} else {
+		statefulSet.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+			{
+				ObjectMeta: v12.ObjectMeta{
+					Name:   "data",
+					Labels: labels,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceStorage: resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return []k8s.Object{&configMap, &service, &headless, &statefulSet}
 }
 
-func copyMap(in map[string]string) map[string]string {
-	out := make(map[string]string)
+// podForDeployment returns the name of a running pod of the given deployment
+func podForDeployment(ctx context.Context, cfg *envconf.Config, namespace, name string) (string, error) {
+	pods, err := podsForDeployment(ctx, cfg, namespace, name)
+	if err != nil {
+		return "", err
+	}
 
-	for k, v := range in {
-		out[k] = v
+	if len(pods) == 0 {
+		return "", fmt.Errorf("no running pod found for deployment %q", name)
 	}
-	return out
+	return pods[0], nil
 }
 
-func eventBusFor(name, namespace string, tags ...*ebv1alpha.Tag) ebv1alpha.EventBus {
-	return ebv1alpha.EventBus{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
-		Spec: ebv1alpha.EventBusSpec{
-			Name: aws.String(name),
-			Tags: tags,
-		},
+// podsForDeployment returns the names of the running pods of the given deployment. Pods are matched by their owner
+// references, so that deployments with overlapping selectors do not return each others pods.
+func podsForDeployment(ctx context.Context, cfg *envconf.Config, namespace, name string) ([]string, error) {
+	var deployment v1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, name, namespace, &deployment); err != nil {
+		return nil, fmt.Errorf("get deployment: %w", err)
+	}
+
+	selector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String()
+
+	var replicaSets v1.ReplicaSetList
+	if err := cfg.Client().Resources(namespace).List(ctx, &replicaSets, resources.WithLabelSelector(selector)); err != nil {
+		return nil, fmt.Errorf("list replica sets: %w", err)
+	}
+
+	owners := make(map[types.UID]bool)
+	for _, rs := range replicaSets.Items {
+		if ownedBy(&rs, deployment.UID) {
+			owners[rs.UID] = true
+		}
 	}
+
+	var pods corev1.PodList
+	if err := cfg.Client().Resources(namespace).List(ctx, &pods, resources.WithLabelSelector(selector)); err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+
+	var running []string
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || !owners[owner.UID] {
+			continue
+		}
+
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			running = append(running, pod.Name)
+		}
+	}
+	sort.Strings(running)
+
+	return running, nil
 }
 
-func ecrSDKClient(t *testing.T) *ecrsvcsdk.ECRPublic {
-	s, err := session.NewSession(&aws.Config{
-		Region: aws.String("us-east-1"), // https://docs.aws.amazon.com/general/latest/gr/ecr-public.html
-	})
-	assert.NilError(t, err, "create ecr service client")
+func ownedBy(obj metav1.Object, uid types.UID) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.UID == uid
+}
 
-	return ecrsvcsdk.New(s)
+// getJSON decodes the response of a GET request for path on the given port of a pod into v
+func getJSON(ctx context.Context, cfg *envconf.Config, namespace, pod string, port int, path string, v any) error {
+	local, stop, err := portForward(ctx, cfg, namespace, pod, port)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", local, path), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("get %q: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q: unexpected status code %d", path, resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
 }
 
-func ebSDKClient(t *testing.T) *ebsvcsdk.EventBridge {
-	s, err := session.NewSession(&aws.Config{
-		Region: aws.String(awscfg.Region),
-	})
-	assert.NilError(t, err, "create eventbridge service client")
+// connectNats connects to the nats servers running in the given pods through port-forwards. The client reconnects
+// to the remaining servers if a pod is deleted. The returned function closes the connection and stops forwarding.
+func connectNats(ctx context.Context, cfg *envconf.Config, namespace string, pods ...string) (*nats.Conn, func(), error) {
+	var (
+		urls  []string
+		stops []func()
+	)
 
-	return ebsvcsdk.New(s)
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+
+	for _, pod := range pods {
+		port, stop, err := portForward(ctx, cfg, namespace, pod, 4222)
+		if err != nil {
+			stopAll()
+			return nil, nil, err
+		}
+		stops = append(stops, stop)
+		urls = append(urls, fmt.Sprintf("nats://127.0.0.1:%d", port))
+	}
+
+	nc, err := nats.Connect(strings.Join(urls, ","), nats.MaxReconnects(-1), nats.ReconnectWait(time.Second))
+	if err != nil {
+		stopAll()
+		return nil, nil, fmt.Errorf("connect to nats: %w", err)
+	}
+
+	return nc, func() {
+		nc.Close()
+		stopAll()
+	}, nil
 }
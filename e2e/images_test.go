@@ -0,0 +1,194 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/pkg/env"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+)
+
+// image builders
+const (
+	imageBuilderKo     = "ko"
+	imageBuilderDocker = "docker"
+)
+
+// dockerfile packages a static binary without a base image, the applications need neither a shell nor certificates
+const dockerfile = `FROM scratch
+COPY app /app
+ENTRYPOINT ["/app"]
+`
+
+// buildImages returns a setup function which builds the publisher and subscriber images for the platform of the host
+// and makes them available to the cluster. Images set with PUBLISHER_IMAGE and SUBSCRIBER_IMAGE are used as is, built
+// images are stored in the configuration of the context.
+func buildImages(cluster clusterProvider) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		c := getConfigFromContext(ctx)
+		if c.ClusterProvider == providerEnvtest {
+			klog.Infof("skipping image builds, the %s cluster cannot run pods", providerEnvtest)
+			return ctx, nil
+		}
+
+		apps := []struct {
+			name  string
+			image *string
+		}{
+			{name: "publisher", image: &c.Publisher},
+			{name: "subscriber", image: &c.Subscriber},
+		}
+
+		root, err := moduleRoot(ctx)
+		if err != nil {
+			return ctx, err
+		}
+
+		for _, app := range apps {
+			if *app.image != "" {
+				klog.Infof("using %s image %q", app.name, *app.image)
+				continue
+			}
+
+			var image string
+			load := true
+			switch c.ImageBuilder {
+			case imageBuilderKo:
+				image, err = koBuild(ctx, root, app.name, cluster.imageRepo(), c.KindCluster)
+				load = cluster.imageRepo() == koLocal
+			case imageBuilderDocker:
+				image, err = dockerBuild(ctx, root, app.name, cluster.imageRepo())
+			default:
+				err = fmt.Errorf("unsupported image builder %q", c.ImageBuilder)
+			}
+			if err == nil && load {
+				ctx, err = cluster.loadImage(ctx, cfg, image)
+			}
+			if err != nil {
+				return ctx, fmt.Errorf("build %s image: %w", app.name, err)
+			}
+
+			klog.Infof("built %s image %q", app.name, image)
+			*app.image = image
+		}
+
+		return withConfig(ctx, c), nil
+	}
+}
+
+// koBuild builds the image of the application in dir with ko into repo. ko loads images into the kind cluster with the
+// repository kind.local and pushes them to registries itself.
+func koBuild(ctx context.Context, root, dir, repo, kindCluster string) (string, error) {
+	platform := "linux/" + runtime.GOARCH
+
+	klog.Infof("building image of %q for %q with ko", dir, platform)
+	cmd := exec.CommandContext(ctx, "ko", "build", "-B", "--platform", platform, "./"+dir)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(),
+		"KO_DOCKER_REPO="+repo,
+		"KIND_CLUSTER_NAME="+kindCluster,
+	)
+
+	out, err := run(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	// ko logs to stderr and prints the image reference last
+	lines := strings.Fields(out)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("ko build returned no image")
+	}
+	return lines[len(lines)-1], nil
+}
+
+// dockerBuild builds a static binary of the application in dir and packages it with docker into repo. The image is
+// tagged with the checksum of the binary, so that unchanged applications are not loaded again.
+func dockerBuild(ctx context.Context, root, dir, repo string) (string, error) {
+	tmp, err := os.MkdirTemp("", "e2e-image-"+dir)
+	if err != nil {
+		return "", fmt.Errorf("create build directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmp); err != nil {
+			klog.Errorf("could not remove build directory %q: %v", tmp, err)
+		}
+	}()
+
+	binary := filepath.Join(tmp, "app")
+
+	klog.Infof("building %q for linux/%s", dir, runtime.GOARCH)
+	build := exec.CommandContext(ctx, "go", "build", "-trimpath", "-o", binary, "./"+dir)
+	build.Dir = root
+	build.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+runtime.GOARCH)
+	if _, err = run(build); err != nil {
+		return "", err
+	}
+
+	sum, err := fileChecksum(binary)
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.WriteFile(filepath.Join(tmp, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
+		return "", fmt.Errorf("write dockerfile: %w", err)
+	}
+
+	image := fmt.Sprintf("%s/%s:%s", repo, dir, sum[:12])
+	klog.Infof("building image %q with docker", image)
+	if _, err = run(exec.CommandContext(ctx, "docker", "build", "-t", image, tmp)); err != nil {
+		return "", err
+	}
+
+	return image, nil
+}
+
+// moduleRoot returns the directory of the go.mod of the tests
+func moduleRoot(ctx context.Context) (string, error) {
+	out, err := run(exec.CommandContext(ctx, "go", "env", "GOMOD"))
+	if err != nil {
+		return "", err
+	}
+
+	gomod := strings.TrimSpace(out)
+	if gomod == "" || gomod == os.DevNull {
+		return "", fmt.Errorf("go.mod not found")
+	}
+	return filepath.Dir(gomod), nil
+}
+
+// run returns the stdout of cmd. The error contains stderr if cmd fails.
+func run(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func fileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open %q: %w", name, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read %q: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
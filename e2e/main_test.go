@@ -2,27 +2,72 @@ package e2e
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/kelseyhightower/envconfig"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/e2e-framework/pkg/env"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
-	"sigs.k8s.io/e2e-framework/pkg/envfuncs"
 	"sigs.k8s.io/e2e-framework/pkg/features"
 )
 
 type config struct {
-	Publisher   string `envconfig:"PUBLISHER_IMAGE" required:"true"`
-	Subscriber  string `envconfig:"SUBSCRIBER_IMAGE" required:"true"`
-	KindCluster string `envconfig:"KIND_CLUSTER_NAME" required:"true"`
+	// Publisher and Subscriber are built with ImageBuilder and loaded into the cluster if empty
+	Publisher  string `envconfig:"PUBLISHER_IMAGE"`
+	Subscriber string `envconfig:"SUBSCRIBER_IMAGE"`
+	// ClusterProvider selects the cluster of the tests, one of "kind", "k3d", "kubeconfig" and "envtest"
+	ClusterProvider string `envconfig:"CLUSTER_PROVIDER" default:"kind"`
+	KindCluster     string `envconfig:"KIND_CLUSTER_NAME"`
+	K3dCluster      string `envconfig:"K3D_CLUSTER_NAME"`
+	// KubeContext selects a context of the kubeconfig with the kubeconfig provider, the current context if empty
+	KubeContext string `envconfig:"KUBE_CONTEXT"`
 	DockerRepo  string `envconfig:"KO_DOCKER_REPO" default:"kind.local"`
+	// ImageBuilder builds images with "ko" or with "docker" from a static binary
+	ImageBuilder string `envconfig:"IMAGE_BUILDER" default:"ko"`
+	// FakeEventBridge runs the eventbridge feature against a fake eventbridge api using this image instead of aws
+	FakeEventBridge string `envconfig:"FAKE_EVENTBRIDGE_IMAGE"`
+	// ChartRegistryLogin authenticates chart pulls with the aws credentials, otherwise charts are pulled anonymously
+	ChartRegistryLogin bool `envconfig:"CHART_REGISTRY_LOGIN" default:"true"`
+	// NatsInstall selects how nats is installed, "manifests" generated in Go or the "chart" in NatsChart
+	NatsInstall string `envconfig:"NATS_INSTALL" default:"manifests"`
+	// NatsChart is the path or url of a nats chart archive, required if NatsInstall is "chart"
+	NatsChart string `envconfig:"NATS_CHART"`
+	// ArtifactsDir receives pod logs, events, resources and helm releases of failed features
+	ArtifactsDir string `envconfig:"ARTIFACTS_DIR" default:"./artifacts"`
+	// ArtifactsAlways collects artifacts of every feature, not only of failed ones
+	ArtifactsAlways bool `envconfig:"ARTIFACTS_ALWAYS"`
+	// CleanupPolicy controls when feature namespaces are deleted, one of "always", "on-success" and "never"
+	CleanupPolicy string `envconfig:"CLEANUP_POLICY" default:"always"`
+	// DestroyCluster deletes a kind or k3d cluster when all tests finished
+	DestroyCluster bool `envconfig:"DESTROY_CLUSTER"`
+
+	// parallel is set from the -parallel flag of the framework
+	parallel bool
 }
 
+// namespace cleanup policies
+const (
+	cleanupAlways    = "always"
+	cleanupOnSuccess = "on-success"
+	cleanupNever     = "never"
+)
+
+type configCtxKey string
+
+const configKey = configCtxKey("suiteConfig")
+
+type featureCtxKey string
+
+// featureFailedKey stores whether the current feature failed, see finishFeature
+const featureFailedKey = featureCtxKey("featureFailed")
+
 var (
 	testEnv env.Environment
-	envCfg  config
+	// suiteCtx is the context of the environment after setup, the environments of parallel features start from it
+	suiteCtx context.Context
 )
 
 func TestMain(m *testing.M) {
@@ -30,19 +75,40 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		klog.Fatalf("could not parse flags: %v", err)
 	}
-	testEnv = env.NewWithConfig(flags)
 
-	if err := envconfig.Process("", &envCfg); err != nil {
+	var c config
+	if err = envconfig.Process("", &c); err != nil {
 		klog.Fatalf("could not parse environment variables: %v", err)
 	}
 
-	klog.Infof("setting up test environment with kind cluster %q", envCfg.KindCluster)
+	switch c.CleanupPolicy {
+	case cleanupAlways, cleanupOnSuccess, cleanupNever:
+	default:
+		klog.Fatalf("unsupported cleanup policy %q", c.CleanupPolicy)
+	}
+
+	if c.NatsInstall == natsInstallChart && c.NatsChart == "" {
+		klog.Fatalf("nats installation method %q requires a chart in NATS_CHART", natsInstallChart)
+	}
+
+	cluster, err := newClusterProvider(c)
+	if err != nil {
+		klog.Fatalf("could not configure cluster: %v", err)
+	}
+
+	testEnv, err = env.NewWithContext(withConfig(context.Background(), c), flags)
+	if err != nil {
+		klog.Fatalf("could not create test environment: %v", err)
+	}
+
 	testEnv.Setup(
-		envfuncs.CreateKindCluster(envCfg.KindCluster),
+		cluster.setup(),
+		buildImages(cluster),
+		saveSuiteContext(),
 	)
 
 	testEnv.Finish(
-	// envfuncs.DestroyKindCluster(envCfg.KindCluster),
+		cluster.finish(c.DestroyCluster),
 	)
 
 	// create/delete namespace per feature
@@ -50,8 +116,83 @@ func TestMain(m *testing.M) {
 		return createNSForFeature(ctx, cfg, f.Name())
 	})
 	testEnv.AfterEachFeature(func(ctx context.Context, cfg *envconf.Config, t *testing.T, f features.Feature) (context.Context, error) {
+		failed, finished := ctx.Value(featureFailedKey).(bool)
+		if !finished {
+			// the feature was skipped or aborted before its teardown, which also fails t. t is shared by all features
+			// of a sequential run, so this keeps the namespace of a skipped feature after a failed one as well.
+			failed = t.Failed()
+		}
+
+		ctx = collectArtifactsOnFailure(ctx, t, cfg, f.Name(), failed)
+		ctx = context.WithValue(ctx, artifactsKey, false)
+		ctx = context.WithValue(ctx, featureFailedKey, nil)
+
+		policy := getConfigFromContext(ctx).CleanupPolicy
+		if policy == cleanupNever || (policy == cleanupOnSuccess && failed) {
+			klog.Infof("keeping namespace %q of feature %q with cleanup policy %q",
+				getTestNamespaceFromContext(ctx, t), f.Name(), policy)
+			return ctx, nil
+		}
 		return deleteNSForFeature(ctx, cfg, t, f.Name())
 	})
 
 	os.Exit(testEnv.Run(m))
 }
+
+// withConfig stores the configuration of the suite in the context. Steps read it with getConfigFromContext instead of
+// sharing mutable package state, setup functions replace it, e.g. with the built images.
+func withConfig(ctx context.Context, c config) context.Context {
+	return context.WithValue(ctx, configKey, c)
+}
+
+func getConfigFromContext(ctx context.Context) config {
+	c, ok := ctx.Value(configKey).(config)
+	if !ok {
+		// the environment is always created with the configuration
+		panic(fmt.Sprintf("retrieve configuration from context: value not found for key %q", configKey))
+	}
+	return c
+}
+
+// saveSuiteContext stores the context after setup in suiteCtx and creates the client of the environment, which is
+// created lazily and not safe for concurrent use until then
+func saveSuiteContext() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		_ = cfg.Client()
+
+		c := getConfigFromContext(ctx)
+		c.parallel = cfg.ParallelTestEnabled()
+		ctx = withConfig(ctx, c)
+
+		suiteCtx = ctx
+		return ctx, nil
+	}
+}
+
+// finishFeature returns the last teardown step of every feature. It records whether the feature failed with the test
+// of the feature, since AfterEachFeature gets the test of all features of a sequential run, and collects the
+// artifacts of a failed feature.
+func finishFeature() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ctx = collectArtifactsOnFailure(ctx, t, cfg, path.Base(t.Name()), t.Failed())
+		return context.WithValue(ctx, featureFailedKey, t.Failed())
+	}
+}
+
+// testFeatures runs the features one after the other, or in parallel with the -parallel flag of the framework. The
+// framework runs parallel features of an environment on a single shared context, so every parallel feature gets its
+// own environment with the hooks of testEnv, its own namespace and its own subtest instead.
+func testFeatures(t *testing.T, feats ...features.Feature) {
+	if !getConfigFromContext(suiteCtx).parallel {
+		testEnv.Test(t, feats...)
+		return
+	}
+
+	for _, f := range feats {
+		f := f
+		t.Run(f.Name(), func(t *testing.T) {
+			t.Parallel()
+			testEnv.WithContext(suiteCtx).Test(t, f)
+		})
+	}
+}
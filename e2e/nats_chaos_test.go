@@ -0,0 +1,199 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+const (
+	// chaosRecoveryTimeout is the deadline for the apps to become ready again after a disruption
+	chaosRecoveryTimeout = 3 * time.Minute
+	// chaosOutage is how long traffic to nats is blocked
+	chaosOutage = 20 * time.Second
+	// chaosPolicy denies all ingress traffic to the nats server
+	chaosPolicy = "deny-nats"
+)
+
+// setupNatsChaos creates a single nats server which keeps its streams and consumers on a persistent volume, so that
+// they survive the deletion of the server pod
+func setupNatsChaos() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		klog.Infof("creating nats %q with persistent storage in namespace %q", natsDeployment, ns)
+		for _, obj := range natsManifestsFor(ns, 1, true) {
+			err := cfg.Client().Resources().Create(ctx, obj)
+			assert.NilError(t, err)
+		}
+
+		return ctx
+	}
+}
+
+// natsServerDeleted force deletes the nats server and asserts that the apps reconnect to the recreated server
+func natsServerDeleted() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		deleteNatsServer(ctx, t, cfg, ns)
+		assertRecovered(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// appsRestarted deletes the pods of publisher and subscriber one after the other and asserts that the replacements
+// resume publishing and consuming where the deleted pods stopped
+func appsRestarted() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		ctx = snapshotPublisher(ctx, t, cfg, ns)
+		for _, name := range []string{"publisher", "subscriber"} {
+			restartPod(ctx, t, cfg, ns, name)
+		}
+
+		assertRecovered(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// trafficBlocked denies all traffic to the nats server with a network policy for chaosOutage and asserts that the
+// publisher buffered messages during the outage and the apps recover once it is removed. The server is restarted after
+// creating the policy, since established connections may still be allowed. The step fails if the network plugin of the
+// cluster does not enforce network policies, e.g. kindnet before kind v0.24.
+func trafficBlocked() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+		r := cfg.Client().Resources()
+
+		policy := denyIngressPolicyFor(ns, chaosPolicy, map[string]string{"app": natsDeployment})
+		klog.Infof("creating network policy %q in namespace %q", chaosPolicy, ns)
+		err := r.Create(ctx, &policy)
+		assert.NilError(t, err)
+
+		// closes established connections
+		deleteNatsServer(ctx, t, cfg, ns)
+
+		klog.Infof("blocking traffic to nats for %s", chaosOutage)
+		time.Sleep(chaosOutage)
+
+		publisher, err := podForDeployment(ctx, cfg, ns, "publisher")
+		assert.NilError(t, err)
+
+		var pub publisherStats
+		err = getJSON(ctx, cfg, ns, publisher, 8080, "/metrics", &pub)
+		assert.NilError(t, err)
+
+		klog.Infof("deleting network policy %q in namespace %q", chaosPolicy, ns)
+		err = r.Delete(ctx, &policy)
+		assert.NilError(t, err)
+
+		assert.Assert(t, pub.BufferDepth > 0,
+			"publisher %q buffered no messages during the outage, network policies are not enforced by the cluster", publisher)
+		klog.Infof("publisher %q buffered %d messages during the outage", publisher, pub.BufferDepth)
+
+		assertRecovered(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// deleteNatsServer force deletes the nats server and waits until the stateful set recreated it and it is ready
+func deleteNatsServer(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) {
+	t.Helper()
+	name := natsDeployment + "-0"
+
+	var pod corev1.Pod
+	err := cfg.Client().Resources().Get(ctx, name, namespace, &pod)
+	assert.NilError(t, err)
+
+	klog.Infof("deleting nats server %q in namespace %q", name, namespace)
+	err = cfg.Client().Resources().Delete(ctx, &pod, resources.WithGracePeriod(0))
+	assert.NilError(t, err)
+
+	klog.Infof("waiting for nats server %q in namespace %q to be recreated", name, namespace)
+	waitCtx, cancel := context.WithTimeout(ctx, chaosRecoveryTimeout)
+	defer cancel()
+	err = wait.For(func(ctx context.Context) (bool, error) {
+		var recreated corev1.Pod
+		if err := cfg.Client().Resources().Get(ctx, name, namespace, &recreated); err != nil {
+			klog.Infof("could not get nats server %q: %v", name, err)
+			return false, nil
+		}
+		return recreated.UID != pod.UID && podReady(&recreated), nil
+	}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+	assert.NilError(t, err)
+}
+
+// restartPod deletes the running pod of a deployment and waits until a replacement is ready
+func restartPod(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace, deployment string) {
+	t.Helper()
+	r := cfg.Client().Resources()
+
+	name, err := podForDeployment(ctx, cfg, namespace, deployment)
+	assert.NilError(t, err)
+
+	klog.Infof("deleting pod %q of deployment %q in namespace %q", name, deployment, namespace)
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	err = r.Delete(ctx, &pod)
+	assert.NilError(t, err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	err = wait.For(conditions.New(r).ResourceDeleted(&pod), wait.WithContext(waitCtx))
+	assert.NilError(t, err)
+
+	klog.Infof("waiting for deployment %q in namespace %q to replace pod %q", deployment, namespace, name)
+	waitDeploymentReady(ctx, t, cfg, namespace, deployment, chaosRecoveryTimeout)
+}
+
+// assertRecovered waits until publisher and subscriber are ready within chaosRecoveryTimeout and asserts that the
+// subscriber caught up without losing messages
+func assertRecovered(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) {
+	t.Helper()
+
+	for _, name := range []string{"publisher", "subscriber"} {
+		klog.Infof("waiting for deployment %q in namespace %q to recover", name, namespace)
+		waitDeploymentReady(ctx, t, cfg, namespace, name, chaosRecoveryTimeout)
+	}
+
+	assertNoMessagesLost(ctx, t, cfg, namespace)
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// denyIngressPolicyFor returns a network policy which denies all ingress traffic to the selected pods
+func denyIngressPolicyFor(namespace, name string, selector map[string]string) networkingv1.NetworkPolicy {
+	return networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    copyMap(commonLabels),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
@@ -0,0 +1,330 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gotest.tools/v3/assert"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+const (
+	natsClusterReplicas = 3
+	subscriberDurable   = "e2e-subscriber"
+	publisherBuffer     = "/var/lib/publisher/buffer"
+)
+
+type publisherCtxKey string
+
+// publisherRunsKey stores the number of messages produced by every replaced publisher, see withPublisherRun
+const publisherRunsKey = publisherCtxKey("publisherRuns")
+
+func setupNatsCluster() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		klog.Infof("creating nats cluster %q with %d servers in namespace %q", natsDeployment, natsClusterReplicas, ns)
+		for _, obj := range natsManifestsFor(ns, natsClusterReplicas, true) {
+			err := cfg.Client().Resources().Create(ctx, obj)
+			assert.NilError(t, err)
+		}
+
+		return ctx
+	}
+}
+
+func natsClusterRunning() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		klog.Infof("waiting for nats cluster %q in namespace %q to become ready", natsDeployment, ns)
+		waitNatsClusterReady(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// durableAppsRunning creates a publisher with a stream of the given replicas and a disk buffer, and a subscriber
+// with a durable consumer, so that neither loses messages while nats is unavailable. A durable push consumer is bound
+// to a single subscription, so a rolling update of the subscriber must stop the old pod before starting a new one.
+func durableAppsRunning(streamReplicas int) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		publisher := natsClientDeployment(ns, "publisher", getConfigFromContext(ctx).Publisher,
+			withEnv("STREAM_REPLICAS", fmt.Sprint(streamReplicas)),
+			withEnv("BUFFER_DIR", publisherBuffer),
+			withEmptyDir("buffer", publisherBuffer),
+		)
+		subscriber := natsClientDeployment(ns, "subscriber", getConfigFromContext(ctx).Subscriber,
+			withEnv("CONSUMER_DURABLE", subscriberDurable),
+			withRollingUpdate(0, 1),
+		)
+
+		// the subscriber only becomes ready after receiving a message
+		for _, d := range []*v1.Deployment{&publisher, &subscriber} {
+			klog.Infof("creating deployment %q", d.Name)
+			err := cfg.Client().Resources().Create(ctx, d)
+			assert.NilError(t, err)
+
+			klog.Infof("waiting for deployment %q in namespace %q to become ready", d.Name, ns)
+			ready := conditions.New(cfg.Client().Resources()).DeploymentConditionMatch(d, v1.DeploymentAvailable, corev1.ConditionTrue)
+			err = wait.For(ready, wait.WithTimeout(time.Minute))
+			assert.NilError(t, err)
+		}
+
+		return ctx
+	}
+}
+
+// streamLeaderKilled force deletes the server which leads the stream and waits for a new leader to be elected and
+// the deleted server to rejoin the cluster
+func streamLeaderKilled() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		leader := streamLeader(ctx, t, cfg, ns)
+
+		klog.Infof("deleting nats stream %q leader %q in namespace %q", natsStreamName, leader, ns)
+		pod := corev1.Pod{ObjectMeta: v12.ObjectMeta{Name: leader, Namespace: ns}}
+		err := cfg.Client().Resources().Delete(ctx, &pod, resources.WithGracePeriod(0))
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for nats stream %q to elect a new leader", natsStreamName)
+		waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			nc, closeNats, err := connectNats(ctx, cfg, ns, runningNatsServers(ctx, cfg, ns)...)
+			if err != nil {
+				klog.Infof("could not connect to nats: %v", err)
+				return false, nil
+			}
+			defer closeNats()
+
+			info, err := streamInfo(nc)
+			if err != nil {
+				klog.Infof("could not get nats stream info: %v", err)
+				return false, nil
+			}
+			return info.Cluster != nil && info.Cluster.Leader != "" && info.Cluster.Leader != leader, nil
+		}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for nats server %q to rejoin the cluster", leader)
+		waitNatsClusterReady(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// noMessagesLost asserts that publisher and subscriber make progress after the failover and lost no messages
+func noMessagesLost() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		assertNoMessagesLost(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// assertNoMessagesLost asserts that publisher and subscriber of durableAppsRunning make progress, that the counters
+// of every publisher run are stored in the stream without gaps, that every run contains at least the messages its
+// publisher produced before it was replaced and that the durable consumer acknowledged all of them. The runs of
+// replaced publishers are recorded with withPublisherRun.
+func assertNoMessagesLost(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) {
+	t.Helper()
+
+	publisher, err := podForDeployment(ctx, cfg, namespace, "publisher")
+	assert.NilError(t, err)
+	subscriber, err := podForDeployment(ctx, cfg, namespace, "subscriber")
+	assert.NilError(t, err)
+
+	var (
+		pubStart publisherStats
+		subStart subscriberStats
+	)
+	err = getJSON(ctx, cfg, namespace, publisher, 8080, "/metrics", &pubStart)
+	assert.NilError(t, err)
+	err = getJSON(ctx, cfg, namespace, subscriber, 8080, "/metrics", &subStart)
+	assert.NilError(t, err)
+
+	klog.Infof("waiting for publisher %q and subscriber %q to make progress", publisher, subscriber)
+	var pub publisherStats
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	err = wait.For(func(ctx context.Context) (bool, error) {
+		var sub subscriberStats
+		if err := getJSON(ctx, cfg, namespace, publisher, 8080, "/metrics", &pub); err != nil {
+			klog.Infof("could not get publisher metrics: %v", err)
+			return false, nil
+		}
+		if err := getJSON(ctx, cfg, namespace, subscriber, 8080, "/metrics", &sub); err != nil {
+			klog.Infof("could not get subscriber metrics: %v", err)
+			return false, nil
+		}
+		return pub.Published > pubStart.Published && pub.BufferDepth == 0 && sub.Received > subStart.Received, nil
+	}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+	assert.NilError(t, err)
+	assert.Equal(t, pub.Dropped, uint64(0), "publisher dropped messages")
+
+	nc, closeNats, err := connectNats(ctx, cfg, namespace, runningNatsServers(ctx, cfg, namespace)...)
+	assert.NilError(t, err)
+	defer closeNats()
+
+	info, err := streamInfo(nc)
+	assert.NilError(t, err)
+	last := info.State.LastSeq
+
+	// the buffer is empty, so the running publisher stored every message it produced
+	replaced, _ := ctx.Value(publisherRunsKey).([]uint64)
+	produced := append(append([]uint64{}, replaced...), pub.Published)
+
+	klog.Infof("asserting nats stream %q contains every message up to sequence %d of %d publisher runs",
+		natsStreamName, last, len(produced))
+	assertPublisherRuns(ctx, t, nc, last, produced)
+
+	js, err := nc.JetStream()
+	assert.NilError(t, err)
+
+	klog.Infof("waiting for consumer %q to acknowledge every message up to sequence %d", subscriberDurable, last)
+	waitCtx, cancel = context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	err = wait.For(func(ctx context.Context) (bool, error) {
+		ci, err := js.ConsumerInfo(natsStreamName, subscriberDurable, nats.Context(ctx))
+		if err != nil {
+			klog.Infof("could not get nats consumer info: %v", err)
+			return false, nil
+		}
+		return ci.AckFloor.Stream >= last && ci.NumAckPending == 0, nil
+	}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+	assert.NilError(t, err)
+}
+
+// assertPublisherRuns reads the stream up to sequence last and asserts that the counters of every publisher run are
+// contiguous and that the stream contains one run for every publisher with at least the number of messages in
+// produced, in the order the publishers started. A counter of 0 starts a new run, e.g. of a restarted publisher, and
+// the runs of a replaced and a new publisher may interleave while the replaced pod terminates. Retried publishes may
+// store a counter twice.
+func assertPublisherRuns(ctx context.Context, t *testing.T, nc *nats.Conn, last uint64, produced []uint64) {
+	t.Helper()
+
+	// last counter of every run
+	var runs []int
+	for i, counter := range streamMessages(ctx, t, nc, last) {
+		if counter == 0 {
+			// a retried first message, the last run did not publish a second one yet
+			if len(runs) == 0 || runs[len(runs)-1] != 0 {
+				runs = append(runs, 0)
+			}
+			continue
+		}
+
+		// prefer continuing a run over a duplicate of another run
+		found := false
+		for r := range runs {
+			if runs[r] == counter-1 {
+				runs[r] = counter
+				found = true
+				break
+			}
+		}
+		for r := range runs {
+			found = found || runs[r] == counter
+		}
+		assert.Assert(t, found, "message %d at position %d of stream %q does not continue a publisher run %v",
+			counter, i, natsStreamName, runs)
+	}
+
+	assert.Equal(t, len(runs), len(produced), "unexpected number of publisher runs %v in stream %q, publishers produced %v",
+		runs, natsStreamName, produced)
+	for i, n := range produced {
+		assert.Assert(t, uint64(runs[i]+1) >= n, "publisher run %d lost messages: stored %d of %d produced messages",
+			i, runs[i]+1, n)
+	}
+}
+
+// snapshotPublisher records the number of messages produced by the running publisher before it is replaced, i.e.
+// published, dropped or buffered. Buffered messages which are not published before the pod is gone are lost with its
+// buffer and fail assertNoMessagesLost.
+func snapshotPublisher(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) context.Context {
+	t.Helper()
+
+	publisher, err := podForDeployment(ctx, cfg, namespace, "publisher")
+	assert.NilError(t, err)
+
+	var pub publisherStats
+	err = getJSON(ctx, cfg, namespace, publisher, 8080, "/metrics", &pub)
+	assert.NilError(t, err)
+	assert.Equal(t, pub.Dropped, uint64(0), "publisher %q dropped messages", publisher)
+
+	klog.Infof("publisher %q produced %d messages, %d of them buffered", publisher, pub.Produced, pub.BufferDepth)
+	return withPublisherRun(ctx, pub.Produced)
+}
+
+// withPublisherRun records the number of messages produced by a replaced publisher for assertNoMessagesLost
+func withPublisherRun(ctx context.Context, produced uint64) context.Context {
+	runs, _ := ctx.Value(publisherRunsKey).([]uint64)
+	return context.WithValue(ctx, publisherRunsKey, append(append([]uint64{}, runs...), produced))
+}
+
+func waitNatsClusterReady(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) {
+	t.Helper()
+
+	server := v1.StatefulSet{ObjectMeta: v12.ObjectMeta{Name: natsDeployment, Namespace: namespace}}
+	ready := conditions.New(cfg.Client().Resources()).ResourceMatch(&server, func(object k8s.Object) bool {
+		s := object.(*v1.StatefulSet)
+		return s.Status.ReadyReplicas == natsClusterReplicas
+	})
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	err := wait.For(ready, wait.WithContext(waitCtx))
+	assert.NilError(t, err)
+}
+
+// runningNatsServers returns the pods of the nats cluster which are not being deleted
+func runningNatsServers(ctx context.Context, cfg *envconf.Config, namespace string) []string {
+	var servers []string
+	for i := 0; i < natsClusterReplicas; i++ {
+		var pod corev1.Pod
+		name := fmt.Sprintf("%s-%d", natsDeployment, i)
+		if err := cfg.Client().Resources().Get(ctx, name, namespace, &pod); err != nil {
+			continue
+		}
+
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			servers = append(servers, name)
+		}
+	}
+	return servers
+}
+
+// streamLeader returns the pod of the server which leads the stream. Server names are the pod names.
+func streamLeader(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace string) string {
+	t.Helper()
+
+	nc, closeNats, err := connectNats(ctx, cfg, namespace, runningNatsServers(ctx, cfg, namespace)...)
+	assert.NilError(t, err)
+	defer closeNats()
+
+	info, err := streamInfo(nc)
+	assert.NilError(t, err)
+	assert.Assert(t, info.Cluster != nil, "stream %q is not replicated", natsStreamName)
+	assert.Assert(t, info.Cluster.Leader != "", "stream %q has no leader", natsStreamName)
+	assert.Equal(t, len(info.Cluster.Replicas), natsClusterReplicas-1, "stream %q is not replicated to every server", natsStreamName)
+
+	return info.Cluster.Leader
+}
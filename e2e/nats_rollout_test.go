@@ -0,0 +1,124 @@
+package e2e
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+const (
+	// rolloutTimeout is the deadline for a rolling update to replace every pod
+	rolloutTimeout = 3 * time.Minute
+	// rolloutEnv is changed to trigger a rolling update, the applications ignore it
+	rolloutEnv = "E2E_ROLLOUT"
+	// publisherShutdownLog is the message of the publisher log line with its final counters
+	publisherShutdownLog = "shutdown complete"
+)
+
+// rolledOut triggers a rolling update of the deployment by changing an environment variable of its pod template while
+// messages are flowing, and asserts that every pod was replaced and no message was lost. The subscriber resumes from
+// the position of its durable consumer. The number of messages produced by a replaced publisher is read from the
+// counters it logs when it terminates, so messages lost until then are detected, too.
+func rolledOut(name string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+		r := cfg.Client().Resources()
+
+		old, err := podsForDeployment(ctx, cfg, ns, name)
+		assert.NilError(t, err)
+
+		var logs io.ReadCloser
+		if name == "publisher" {
+			assert.Equal(t, len(old), 1, "unexpected publisher pods %v", old)
+
+			logCtx, cancel := context.WithTimeout(ctx, rolloutTimeout)
+			defer cancel()
+			logs, err = followPodLogs(logCtx, cfg, ns, old[0])
+			assert.NilError(t, err)
+			defer logs.Close()
+		}
+
+		revision := fmt.Sprint(time.Now().Unix())
+		klog.Infof("rolling out deployment %q in namespace %q with %s=%s", name, ns, rolloutEnv, revision)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var d v1.Deployment
+			if err := r.Get(ctx, name, ns, &d); err != nil {
+				return err
+			}
+			withEnv(rolloutEnv, revision)(&d)
+			return r.Update(ctx, &d)
+		})
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for rollout of deployment %q in namespace %q", name, ns)
+		waitDeploymentReady(ctx, t, cfg, ns, name, rolloutTimeout)
+
+		pods, err := podsForDeployment(ctx, cfg, ns, name)
+		assert.NilError(t, err)
+		for _, pod := range pods {
+			for _, o := range old {
+				assert.Assert(t, pod != o, "pod %q of deployment %q was not replaced", pod, name)
+			}
+		}
+
+		if logs != nil {
+			klog.Infof("reading final counters of publisher %q", old[0])
+			pub, err := publisherShutdownStats(logs)
+			assert.NilError(t, err, "publisher %q", old[0])
+			assert.Equal(t, pub.Dropped, uint64(0), "publisher %q dropped messages", old[0])
+
+			klog.Infof("publisher %q produced %d messages, %d of them left in its buffer", old[0], pub.Produced, pub.BufferDepth)
+			ctx = withPublisherRun(ctx, pub.Produced)
+		}
+
+		assertNoMessagesLost(ctx, t, cfg, ns)
+
+		return ctx
+	}
+}
+
+// publisherShutdownStats reads the logs of a publisher until it terminated and returns the counters it logged last
+func publisherShutdownStats(logs io.Reader) (publisherStats, error) {
+	var (
+		stats publisherStats
+		found bool
+	)
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		// e.g. <time>\tINFO\tpublisher\t<caller>\tshutdown complete\t{"stats": {...}}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 || fields[len(fields)-2] != publisherShutdownLog {
+			continue
+		}
+
+		var line struct {
+			Stats publisherStats `json:"stats"`
+		}
+		if err := json.Unmarshal([]byte(fields[len(fields)-1]), &line); err != nil {
+			return publisherStats{}, fmt.Errorf("decode counters: %w", err)
+		}
+		stats, found = line.Stats, true
+	}
+	if err := scanner.Err(); err != nil {
+		return publisherStats{}, fmt.Errorf("read logs: %w", err)
+	}
+
+	if !found {
+		return publisherStats{}, errors.New("no counters logged at shutdown")
+	}
+	return stats, nil
+}
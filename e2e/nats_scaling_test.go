@@ -0,0 +1,242 @@
+package e2e
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gotest.tools/v3/assert"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+const (
+	scaleReplicas = 3
+	scaleMessages = 300
+	// scaleQueue is the deliver group of the durable consumer shared by the subscriber replicas
+	scaleQueue = "e2e-subscribers"
+)
+
+// connz is the part of the connection report of the nats monitoring endpoint with subscription details
+type connz struct {
+	Connections []struct {
+		Subscriptions []struct {
+			Subject string `json:"subject"`
+			Queue   string `json:"qgroup"`
+		} `json:"subscriptions_list_detail"`
+	} `json:"connections"`
+}
+
+// streamCreated creates the stream of the publisher, the feature publishes a known number of messages itself
+func streamCreated() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		nc, closeNats, err := connectNats(ctx, cfg, ns, natsDeployment+"-0")
+		assert.NilError(t, err)
+		defer closeNats()
+
+		js, err := nc.JetStream()
+		assert.NilError(t, err)
+
+		klog.Infof("creating nats stream %q in namespace %q", natsStreamName, ns)
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     natsStreamName,
+			Subjects: []string{natsStream},
+		}, nats.Context(ctx))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+// subscriberScaled creates a subscriber with a durable consumer and deliver group and scales it to scaleReplicas.
+// It waits until every replica joined the queue group, since subscribers only become ready after receiving a message.
+// The replicas write received messages to stdout, see receivedSequences.
+func subscriberScaled() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+		r := cfg.Client().Resources()
+
+		name := "subscriber"
+		subscriber := natsClientDeployment(ns, name, getConfigFromContext(ctx).Subscriber,
+			withEnv("CONSUMER_DURABLE", subscriberDurable),
+			withEnv("CONSUMER_QUEUE", scaleQueue),
+			withEnv("SINKS", "stdout"),
+		)
+		klog.Infof("creating deployment %q", name)
+		err := r.Create(ctx, &subscriber)
+		assert.NilError(t, err)
+
+		klog.Infof("scaling deployment %q in namespace %q to %d replicas", name, ns, scaleReplicas)
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var d v1.Deployment
+			if err := r.Get(ctx, name, ns, &d); err != nil {
+				return err
+			}
+			withReplicas(scaleReplicas)(&d)
+			return r.Update(ctx, &d)
+		})
+		assert.NilError(t, err)
+
+		server := natsDeployment + "-0"
+		klog.Infof("waiting for %d subscribers in queue group %q of nats %q", scaleReplicas, scaleQueue, server)
+		waitCtx, cancel := context.WithTimeout(ctx, time.Minute*2)
+		defer cancel()
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			pods, err := podsForDeployment(ctx, cfg, ns, name)
+			if err != nil {
+				klog.Infof("could not list pods of deployment %q: %v", name, err)
+				return false, nil
+			}
+
+			var report connz
+			if err = getJSON(ctx, cfg, ns, server, 8222, "/connz?subs=detail", &report); err != nil {
+				klog.Infof("could not get nats connections: %v", err)
+				return false, nil
+			}
+
+			members := 0
+			for _, c := range report.Connections {
+				for _, s := range c.Subscriptions {
+					if s.Queue == scaleQueue {
+						members++
+					}
+				}
+			}
+			return len(pods) == scaleReplicas && members == scaleReplicas, nil
+		}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+
+		return ctx
+	}
+}
+
+// messagesPublished publishes scaleMessages messages in the format of the publisher
+func messagesPublished() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		nc, closeNats, err := connectNats(ctx, cfg, ns, natsDeployment+"-0")
+		assert.NilError(t, err)
+		defer closeNats()
+
+		js, err := nc.JetStream()
+		assert.NilError(t, err)
+
+		klog.Infof("publishing %d messages to nats stream %q", scaleMessages, natsStreamName)
+		for i := 0; i < scaleMessages; i++ {
+			msg := fmt.Sprintf("test message: %d @%s", i, time.Now().UTC().String())
+			_, err = js.Publish(natsStream, []byte(msg), nats.Context(ctx))
+			assert.NilError(t, err)
+		}
+
+		return ctx
+	}
+}
+
+// messagesProcessedOnce asserts that the subscriber replicas together received every message of the stream exactly
+// once and that every replica received a share of them
+func messagesProcessedOnce() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+
+		pods, err := podsForDeployment(ctx, cfg, ns, "subscriber")
+		assert.NilError(t, err)
+		assert.Equal(t, len(pods), scaleReplicas)
+
+		nc, closeNats, err := connectNats(ctx, cfg, ns, natsDeployment+"-0")
+		assert.NilError(t, err)
+		defer closeNats()
+
+		info, err := streamInfo(nc)
+		assert.NilError(t, err)
+		assert.Equal(t, info.State.Msgs, uint64(scaleMessages), "unexpected number of messages in stream %q", natsStreamName)
+
+		klog.Infof("asserting nats stream %q contains every message up to sequence %d", natsStreamName, info.State.LastSeq)
+		counters := streamCounters(ctx, t, nc, info.State.LastSeq)
+		assert.Equal(t, len(counters), scaleMessages)
+		for i := 0; i < len(counters); i++ {
+			assert.Assert(t, counters[i], "message %d missing in stream %q", i, natsStreamName)
+		}
+
+		js, err := nc.JetStream()
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for consumer %q to acknowledge every message up to sequence %d", subscriberDurable, info.State.LastSeq)
+		waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			ci, err := js.ConsumerInfo(natsStreamName, subscriberDurable, nats.Context(ctx))
+			if err != nil {
+				klog.Infof("could not get nats consumer info: %v", err)
+				return false, nil
+			}
+			return ci.AckFloor.Stream >= info.State.LastSeq && ci.NumAckPending == 0, nil
+		}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+
+		// replica which received every stream sequence
+		receivers := make(map[uint64][]string, scaleMessages)
+		received := make(map[string]int, len(pods))
+		for _, pod := range pods {
+			sequences, err := receivedSequences(ctx, cfg, ns, pod)
+			assert.NilError(t, err)
+
+			for _, seq := range sequences {
+				receivers[seq] = append(receivers[seq], pod)
+			}
+			received[pod] = len(sequences)
+		}
+		klog.Infof("subscribers received %v messages", received)
+
+		for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+			assert.Equal(t, len(receivers[seq]), 1, "message %d was not processed exactly once: %v", seq, receivers[seq])
+		}
+		assert.Equal(t, len(receivers), scaleMessages, "subscribers received messages not in stream %q", natsStreamName)
+		for pod, n := range received {
+			assert.Assert(t, n > 0, "subscriber %q received no messages: %v", pod, received)
+		}
+
+		return ctx
+	}
+}
+
+// receivedSequences returns the stream sequences of the messages a subscriber pod wrote to its stdout sink, in the
+// order they were received. Log lines of the subscriber are skipped.
+func receivedSequences(ctx context.Context, cfg *envconf.Config, namespace, pod string) ([]uint64, error) {
+	logs, err := podLogs(ctx, cfg, namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	var sequences []uint64
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		var r struct {
+			StreamSequence uint64 `json:"streamSequence"`
+		}
+		if err = json.Unmarshal([]byte(line), &r); err != nil {
+			return nil, fmt.Errorf("decode record of subscriber %q: %w", pod, err)
+		}
+		sequences = append(sequences, r.StreamSequence)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read logs of subscriber %q: %w", pod, err)
+	}
+	return sequences, nil
+}
@@ -2,12 +2,18 @@ package e2e
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
 	"k8s.io/api/apps/v1"
 	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/e2e-framework/klient/k8s"
 	"sigs.k8s.io/e2e-framework/klient/wait"
@@ -18,38 +24,67 @@ import (
 )
 
 const (
-	natsRepo         = "nats"
-	natsChart        = "https://nats-io.github.io/k8s/helm/charts/"
-	natsChartVersion = "0.19.12"
-	natsRelease      = "nats/nats"
-	natsConfig       = "./testdata/nats.config"
-	natsDeployment   = "nats-server"
-	natsStream       = "e2e-topic"
+	natsConfig     = "./testdata/nats.config"
+	natsDeployment = "nats-server"
+	natsHeadless   = "nats-server-headless"
+	natsStream     = "e2e-topic"
+	// natsStreamName is the stream created by the publisher
+	natsStreamName = "e2e"
+	natsImage      = "nats:2.9.15-alpine"
+	natsContainer  = "nats"
+
+	// nats installation methods
+	natsInstallManifests = "manifests"
+	natsInstallChart     = "chart"
+
+	// natsMinMessages is the number of messages published before asserting delivery
+	natsMinMessages = 10
+)
+
+// publisherStats and subscriberStats are the counters of the metrics endpoints used by the tests
+type (
+	publisherStats struct {
+		Produced    uint64 `json:"produced"`
+		Published   uint64 `json:"published"`
+		Dropped     uint64 `json:"dropped"`
+		BufferDepth int64  `json:"bufferDepth"`
+	}
+
+	subscriberStats struct {
+		Received uint64 `json:"received"`
+	}
 )
 
+// setupNats installs nats without network access to a chart repository and without changing the helm repository
+// configuration, either from manifests generated in Go or from the vendored chart
 func setupNats() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
-		hm := helm.New(cfg.KubeconfigFile())
+		ns := getTestNamespaceFromContext(ctx, t)
+		c := getConfigFromContext(ctx)
 
-		klog.Infof("adding nats repo %q using chart %q", natsRepo, natsChart)
-		opts := []helm.Option{helm.WithArgs("add", natsRepo, natsChart)}
-		err := hm.RunRepo(opts...)
-		assert.NilError(t, err)
+		switch c.NatsInstall {
+		case natsInstallManifests:
+			klog.Infof("creating nats %q in namespace %q from manifests", natsDeployment, ns)
+			for _, obj := range natsManifestsFor(ns, 1, false) {
+				err := cfg.Client().Resources().Create(ctx, obj)
+				assert.NilError(t, err)
+			}
 
-		err = hm.RunRepo(helm.WithArgs("update"))
-		assert.NilError(t, err)
+		case natsInstallChart:
+			hm := helm.New(cfg.KubeconfigFile())
+			klog.Infof("installing nats %q in namespace %q from chart %q", natsDeployment, ns, c.NatsChart)
+			opts := []helm.Option{
+				helm.WithName(natsDeployment),
+				helm.WithNamespace(ns),
+				helm.WithChart(c.NatsChart),
+				helm.WithArgs("-f", natsConfig),
+			}
+			err := hm.RunInstall(opts...)
+			assert.NilError(t, err)
 
-		ns := getTestNamespaceFromContext(ctx, t)
-		klog.Infof("installing nats %q in namespace %q with version %q", natsDeployment, ns, natsChartVersion)
-		opts = []helm.Option{
-			helm.WithName(natsDeployment),
-			helm.WithNamespace(ns),
-			helm.WithVersion(natsChartVersion),
-			helm.WithReleaseName(natsRelease),
-			helm.WithArgs("-f", natsConfig),
+		default:
+			t.Fatalf("unsupported nats installation method %q", c.NatsInstall)
 		}
-		err = hm.RunInstall(opts...)
-		assert.NilError(t, err)
 
 		return ctx
 	}
@@ -76,12 +111,52 @@ func natsRunning() features.Func {
 	}
 }
 
+// natsReachable connects to nats through a port-forward to the service and queries the health endpoint of the
+// server through a port-forward to the pod, from inside the namespace with a job and with an exec into the server
+func natsReachable() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+		server := natsDeployment + "-0"
+		healthz := ":8222/healthz?js-enabled-only=true"
+
+		port := forwardServicePort(ctx, t, cfg, ns, natsDeployment, 4222)
+		klog.Infof("connecting to nats %q through port-forward", natsDeployment)
+		nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", port))
+		assert.NilError(t, err)
+		defer nc.Close()
+
+		_, err = nc.RTT()
+		assert.NilError(t, err)
+
+		port = forwardPodPort(ctx, t, cfg, ns, server, 8222)
+		klog.Infof("querying nats %q health through port-forward", server)
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz?js-enabled-only=true", port))
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+		var pod v12.Pod
+		err = cfg.Client().Resources().Get(ctx, server, ns, &pod)
+		assert.NilError(t, err)
+
+		out, err := runJob(ctx, cfg, ns, "nats-healthz", natsImage, "wget", "-q", "-O", "-", "http://"+pod.Status.PodIP+healthz)
+		assert.NilError(t, err)
+		assert.Assert(t, cmp.Contains(out, `"ok"`), "unexpected nats health %q", out)
+
+		out, err = podExec(ctx, cfg, ns, server, natsContainer, "wget", "-q", "-O", "-", "http://127.0.0.1"+healthz)
+		assert.NilError(t, err)
+		assert.Assert(t, cmp.Contains(out, `"ok"`), "unexpected nats health %q", out)
+
+		return ctx
+	}
+}
+
 func publisherRunning() features.Func {
 	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 		ns := getTestNamespaceFromContext(ctx, t)
 
 		name := "publisher"
-		publisher := newDeployment(ns, name, 1, envCfg.Publisher)
+		publisher := natsClientDeployment(ns, name, getConfigFromContext(ctx).Publisher)
 		klog.Infof("creating deployment %q", name)
 		err := cfg.Client().Resources().Create(ctx, &publisher)
 		assert.NilError(t, err)
@@ -103,7 +178,7 @@ func subscriberRunning() features.Func {
 		ns := getTestNamespaceFromContext(ctx, t)
 
 		name := "subscriber"
-		subscriber := newDeployment(ns, name, 1, envCfg.Subscriber)
+		subscriber := natsClientDeployment(ns, name, getConfigFromContext(ctx).Subscriber)
 		klog.Infof("creating deployment %q", name)
 		err := cfg.Client().Resources().Create(ctx, &subscriber)
 		assert.NilError(t, err)
@@ -119,3 +194,143 @@ func subscriberRunning() features.Func {
 		return ctx
 	}
 }
+
+// messagesDelivered stops the publisher after it published natsMinMessages and asserts that the stream contains
+// every message counter without gaps and that the subscriber received as many messages as the stream contains
+func messagesDelivered() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ns := getTestNamespaceFromContext(ctx, t)
+		r := cfg.Client().Resources()
+
+		name, err := podForDeployment(ctx, cfg, ns, "publisher")
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for publisher %q to publish %d messages", name, natsMinMessages)
+		waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			var pub publisherStats
+			if err := getJSON(ctx, cfg, ns, name, 8080, "/metrics", &pub); err != nil {
+				klog.Infof("could not get publisher metrics: %v", err)
+				return false, nil
+			}
+			return pub.Published >= natsMinMessages, nil
+		}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+
+		// the stream is final once the publisher is gone
+		klog.Infof("scaling down deployment %q in namespace %q", "publisher", ns)
+		var publisher v1.Deployment
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if err := r.Get(ctx, "publisher", ns, &publisher); err != nil {
+				return err
+			}
+			publisher.Spec.Replicas = new(int32)
+			return r.Update(ctx, &publisher)
+		})
+		assert.NilError(t, err)
+
+		pod := v12.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}}
+		waitCtx, cancel = context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err = wait.For(conditions.New(r).ResourceDeleted(&pod), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+
+		nc, closeNats, err := connectNats(ctx, cfg, ns, natsDeployment+"-0")
+		assert.NilError(t, err)
+		defer closeNats()
+
+		info, err := streamInfo(nc)
+		assert.NilError(t, err)
+		assert.Assert(t, info.State.Msgs >= natsMinMessages, "stream %q contains %d messages", natsStreamName, info.State.Msgs)
+
+		klog.Infof("asserting nats stream %q contains every message up to sequence %d", natsStreamName, info.State.LastSeq)
+		counters := streamCounters(ctx, t, nc, info.State.LastSeq)
+		for i := 0; i < len(counters); i++ {
+			assert.Assert(t, counters[i], "message %d missing in stream %q", i, natsStreamName)
+		}
+
+		name, err = podForDeployment(ctx, cfg, ns, "subscriber")
+		assert.NilError(t, err)
+
+		klog.Infof("waiting for subscriber %q to receive %d messages", name, info.State.Msgs)
+		var sub subscriberStats
+		waitCtx, cancel = context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err = wait.For(func(ctx context.Context) (bool, error) {
+			if err := getJSON(ctx, cfg, ns, name, 8080, "/metrics", &sub); err != nil {
+				klog.Infof("could not get subscriber metrics: %v", err)
+				return false, nil
+			}
+			return sub.Received >= info.State.Msgs, nil
+		}, wait.WithInterval(time.Second*2), wait.WithContext(waitCtx))
+		assert.NilError(t, err)
+		assert.Equal(t, sub.Received, info.State.Msgs, "subscriber received unexpected number of messages")
+
+		return ctx
+	}
+}
+
+func streamInfo(nc *nats.Conn) (*nats.StreamInfo, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("create jetstream context: %w", err)
+	}
+
+	info, err := js.StreamInfo(natsStreamName)
+	if err != nil {
+		return nil, fmt.Errorf("get stream info: %w", err)
+	}
+	return info, nil
+}
+
+// streamCounters reads the stream up to sequence last and returns which publisher message counters were found.
+// Retried publishes may store a counter more than once.
+func streamCounters(ctx context.Context, t *testing.T, nc *nats.Conn, last uint64) []bool {
+	t.Helper()
+
+	var counters []bool
+	for _, counter := range streamMessages(ctx, t, nc, last) {
+		for len(counters) <= counter {
+			counters = append(counters, false)
+		}
+		counters[counter] = true
+	}
+	return counters
+}
+
+// streamMessages reads the stream up to sequence last with an ordered consumer and returns the publisher message
+// counters in stream order
+func streamMessages(ctx context.Context, t *testing.T, nc *nats.Conn, last uint64) []int {
+	t.Helper()
+
+	js, err := nc.JetStream()
+	assert.NilError(t, err)
+
+	sub, err := js.SubscribeSync(natsStream, nats.OrderedConsumer(), nats.DeliverAll())
+	assert.NilError(t, err)
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var counters []int
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		assert.NilError(t, err)
+
+		md, err := msg.Metadata()
+		assert.NilError(t, err)
+
+		var counter int
+		_, err = fmt.Sscanf(string(msg.Data), "test message: %d", &counter)
+		assert.NilError(t, err, "unexpected message %q", string(msg.Data))
+		counters = append(counters, counter)
+
+		if md.Sequence.Stream >= last {
+			return counters
+		}
+	}
+}
@@ -11,8 +11,53 @@ func TestDemoMultipleE2E(t *testing.T) {
 		WithLabel("feature", "e2e-nats").
 		Setup(setupNats()).
 		Assess("nats server running", natsRunning()).
+		Assess("nats server reachable", natsReachable()).
 		Assess("publisher running", publisherRunning()).
 		Assess("subscriber received message", subscriberRunning()).
+		Assess("subscriber received every message", messagesDelivered()).
+		Teardown(finishFeature()).
+		Feature()
+
+	failover := features.New("e2e nats failover").
+		WithLabel("feature", "e2e-nats-failover").
+		Setup(setupNatsCluster()).
+		Assess("nats cluster running", natsClusterRunning()).
+		Assess("publisher and subscriber running", durableAppsRunning(natsClusterReplicas)).
+		Assess("stream leader killed", streamLeaderKilled()).
+		Assess("no messages lost", noMessagesLost()).
+		Teardown(finishFeature()).
+		Feature()
+
+	chaos := features.New("e2e nats chaos").
+		WithLabel("feature", "e2e-nats-chaos").
+		Setup(setupNatsChaos()).
+		Assess("nats server running", natsRunning()).
+		Assess("publisher and subscriber running", durableAppsRunning(1)).
+		Assess("nats server deleted", natsServerDeleted()).
+		Assess("publisher and subscriber restarted", appsRestarted()).
+		Assess("traffic to nats blocked", trafficBlocked()).
+		Teardown(finishFeature()).
+		Feature()
+
+	rollout := features.New("e2e nats rollout").
+		WithLabel("feature", "e2e-nats-rollout").
+		Setup(setupNats()).
+		Assess("nats server running", natsRunning()).
+		Assess("publisher and subscriber running", durableAppsRunning(1)).
+		Assess("publisher rolled out", rolledOut("publisher")).
+		Assess("subscriber rolled out", rolledOut("subscriber")).
+		Teardown(finishFeature()).
+		Feature()
+
+	scaling := features.New("e2e nats scaling").
+		WithLabel("feature", "e2e-nats-scaling").
+		Setup(setupNats()).
+		Assess("nats server running", natsRunning()).
+		Assess("stream created", streamCreated()).
+		Assess("subscriber scaled", subscriberScaled()).
+		Assess("messages published", messagesPublished()).
+		Assess("messages processed exactly once", messagesProcessedOnce()).
+		Teardown(finishFeature()).
 		Feature()
 
 	eb := features.New("e2e demo with eventbridge").
@@ -20,8 +65,17 @@ func TestDemoMultipleE2E(t *testing.T) {
 		Setup(setupEventBridge()).
 		Teardown(teardownEventBridge()).
 		Assess("event bus created", eventbusCreated()).
+		Assess("event bus tags updated", eventbusTagsUpdated()).
+		Assess("rule created", ruleCreated()).
+		Assess("rule updated", ruleUpdated()).
+		Assess("rule deleted", ruleDeleted()).
+		Assess("invalid rule reports terminal condition", invalidRuleTerminal()).
+		Assess("invalid event bus reports recoverable condition", invalidEventBusRecoverable()).
+		Assess("denied event bus reports recoverable condition", deniedEventBusRecoverable()).
+		Assess("duplicate event bus reports conflict", duplicateEventBusRejected()).
 		Assess("event bus deleted", eventbusDeleted()).
+		Teardown(finishFeature()).
 		Feature()
 
-	testEnv.Test(t, nats, eb)
+	testFeatures(t, nats, failover, chaos, rollout, scaling, eb)
 }
@@ -0,0 +1,197 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+)
+
+// deploymentOption configures a deployment created with newDeployment
+type deploymentOption func(d *v1.Deployment)
+
+// newDeployment returns a deployment with a single replica of a single container, both named name. Pods are
+// selected by the app label, which is set to name.
+func newDeployment(namespace, name, image string, opts ...deploymentOption) v1.Deployment {
+	labels := copyMap(commonLabels)
+	labels["app"] = name
+
+	replicas := int32(1)
+	d := v1.Deployment{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    copyMap(labels),
+		},
+		Spec: v1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &v12.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v12.ObjectMeta{
+					Labels: copyMap(labels),
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            name,
+							Image:           image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return d
+}
+
+// natsClientDeployment returns a deployment of the publisher or subscriber connected to the nats server and topic of
+// the test namespace
+func natsClientDeployment(namespace, name, image string, opts ...deploymentOption) v1.Deployment {
+	defaults := []deploymentOption{
+		withEnv("NATS_SERVER", fmt.Sprintf("%s.%s.svc.cluster.local", natsDeployment, namespace)),
+		withEnv("NATS_TOPIC", natsStream),
+		withEnv("HEALTHZ_ADDRESS", ":8080"),
+		withEnv("HEALTHZ_PATH", "/healthz"),
+		withPort("http", 8080),
+		withReadinessProbe(httpProbe("/healthz", 8080)),
+	}
+	return newDeployment(namespace, name, image, append(defaults, opts...)...)
+}
+
+// httpProbe returns a probe which fails after a single failed GET request of path
+func httpProbe(path string, port int) corev1.Probe {
+	return corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(port),
+			},
+		},
+		InitialDelaySeconds: 1,
+		TimeoutSeconds:      3,
+		PeriodSeconds:       1,
+		SuccessThreshold:    1,
+		FailureThreshold:    1,
+	}
+}
+
+func container(d *v1.Deployment) *corev1.Container {
+	return &d.Spec.Template.Spec.Containers[0]
+}
+
+func withReplicas(replicas int32) deploymentOption {
+	return func(d *v1.Deployment) {
+		d.Spec.Replicas = &replicas
+	}
+}
+
+// withEnv sets an environment variable of the container, replacing an existing value
+func withEnv(name, value string) deploymentOption {
+	return func(d *v1.Deployment) {
+		c := container(d)
+		for i := range c.Env {
+			if c.Env[i].Name == name {
+				c.Env[i] = corev1.EnvVar{Name: name, Value: value}
+				return
+			}
+		}
+		c.Env = append(c.Env, corev1.EnvVar{Name: name, Value: value})
+	}
+}
+
+func withPort(name string, port int32) deploymentOption {
+	return func(d *v1.Deployment) {
+		c := container(d)
+		c.Ports = append(c.Ports, corev1.ContainerPort{Name: name, ContainerPort: port})
+	}
+}
+
+func withReadinessProbe(probe corev1.Probe) deploymentOption {
+	return func(d *v1.Deployment) {
+		container(d).ReadinessProbe = &probe
+	}
+}
+
+// withLabels adds labels to the deployment and its pods. The selector is not changed.
+func withLabels(labels map[string]string) deploymentOption {
+	return func(d *v1.Deployment) {
+		for k, v := range labels {
+			d.Labels[k] = v
+			d.Spec.Template.Labels[k] = v
+		}
+	}
+}
+
+// withVolume adds a volume to the pods and mounts it into the container at path
+func withVolume(volume corev1.Volume, path string) deploymentOption {
+	return func(d *v1.Deployment) {
+		d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, volume)
+		c := container(d)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: volume.Name, MountPath: path})
+	}
+}
+
+// withEmptyDir mounts an empty directory named name into the container at path
+func withEmptyDir(name, path string) deploymentOption {
+	return withVolume(corev1.Volume{
+		Name:         name,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}, path)
+}
+
+// withRollingUpdate sets the number of pods a rolling update may create above and take down below the replicas
+func withRollingUpdate(maxSurge, maxUnavailable int) deploymentOption {
+	return func(d *v1.Deployment) {
+		surge := intstr.FromInt(maxSurge)
+		unavailable := intstr.FromInt(maxUnavailable)
+		d.Spec.Strategy = v1.DeploymentStrategy{
+			Type: v1.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: &v1.RollingUpdateDeployment{
+				MaxSurge:       &surge,
+				MaxUnavailable: &unavailable,
+			},
+		}
+	}
+}
+
+// waitDeploymentReady waits until every replica of the deployment is updated and ready and no replaced pod is left
+func waitDeploymentReady(ctx context.Context, t *testing.T, cfg *envconf.Config, namespace, name string, timeout time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.For(func(ctx context.Context) (bool, error) {
+		var d v1.Deployment
+		if err := cfg.Client().Resources().Get(ctx, name, namespace, &d); err != nil {
+			klog.Infof("could not get deployment %q: %v", name, err)
+			return false, nil
+		}
+
+		pods, err := podsForDeployment(ctx, cfg, namespace, name)
+		if err != nil {
+			klog.Infof("could not list pods of deployment %q: %v", name, err)
+			return false, nil
+		}
+
+		replicas := *d.Spec.Replicas
+		return d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.ReadyReplicas == replicas && d.Status.Replicas == replicas && len(pods) == int(replicas), nil
+	}, wait.WithInterval(time.Second*2), wait.WithContext(ctx))
+	assert.NilError(t, err, "deployment %q did not become ready", name)
+}
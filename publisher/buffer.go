@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const bufferFileExt = ".msg"
+
+var errBufferFull = errors.New("buffer full")
+
+// diskBuffer is a bounded FIFO buffer which persists each message as a file in dir. File names are zero-padded
+// sequence numbers so that the order survives publisher restarts.
+type diskBuffer struct {
+	dir      string
+	maxBytes int64
+	maxCount int
+
+	entries []bufferEntry
+	bytes   int64
+	next    uint64
+}
+
+type bufferEntry struct {
+	name string
+	size int64
+}
+
+// openDiskBuffer creates dir if needed and loads messages left over from a previous run
+func openDiskBuffer(dir string, maxBytes int64, maxCount int) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create buffer directory: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read buffer directory: %w", err)
+	}
+
+	b := diskBuffer{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxCount: maxCount,
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() {
+			continue
+		}
+
+		// incomplete write
+		if strings.HasSuffix(name, ".tmp") {
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, fmt.Errorf("remove incomplete buffer file: %w", err)
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(name, bufferFileExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, bufferFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			return nil, fmt.Errorf("read buffer file info: %w", err)
+		}
+
+		b.entries = append(b.entries, bufferEntry{name: name, size: info.Size()})
+		b.bytes += info.Size()
+		if seq >= b.next {
+			b.next = seq + 1
+		}
+	}
+
+	sort.Slice(b.entries, func(i, j int) bool {
+		return b.entries[i].name < b.entries[j].name
+	})
+	b.updateStats()
+
+	return &b, nil
+}
+
+// append persists data at the end of the buffer. It returns errBufferFull if the message would exceed the configured
+// limits.
+func (b *diskBuffer) append(data []byte) error {
+	size := int64(len(data))
+	if len(b.entries)+1 > b.maxCount || b.bytes+size > b.maxBytes {
+		return errBufferFull
+	}
+
+	name := fmt.Sprintf("%020d%s", b.next, bufferFileExt)
+	path := filepath.Join(b.dir, name)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return fmt.Errorf("write buffer file: %w", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("rename buffer file: %w", err)
+	}
+
+	b.next++
+	b.entries = append(b.entries, bufferEntry{name: name, size: size})
+	b.bytes += size
+	stats.buffered.Add(1)
+	b.updateStats()
+
+	return nil
+}
+
+// peek returns the oldest message in the buffer
+func (b *diskBuffer) peek() ([]byte, error) {
+	if len(b.entries) == 0 {
+		return nil, errors.New("buffer empty")
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.dir, b.entries[0].name))
+	if err != nil {
+		return nil, fmt.Errorf("read buffer file: %w", err)
+	}
+	return data, nil
+}
+
+// remove deletes the oldest message from the buffer
+func (b *diskBuffer) remove() error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	head := b.entries[0]
+	if err := os.Remove(filepath.Join(b.dir, head.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove buffer file: %w", err)
+	}
+
+	b.entries = b.entries[1:]
+	b.bytes -= head.size
+	b.updateStats()
+
+	return nil
+}
+
+func (b *diskBuffer) len() int {
+	return len(b.entries)
+}
+
+func (b *diskBuffer) updateStats() {
+	stats.bufferDepth.Store(int64(len(b.entries)))
+	stats.bufferBytes.Store(b.bytes)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"gotest.tools/v3/assert"
+)
+
+func TestDiskBufferFIFO(t *testing.T) {
+	b, err := openDiskBuffer(t.TempDir(), 1024, 10)
+	assert.NilError(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.NilError(t, b.append([]byte(fmt.Sprintf("message %d", i))))
+	}
+	assert.Equal(t, b.len(), 5)
+
+	for i := 0; i < 5; i++ {
+		data, err := b.peek()
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), fmt.Sprintf("message %d", i))
+		assert.NilError(t, b.remove())
+	}
+
+	assert.Equal(t, b.len(), 0)
+	assert.Equal(t, stats.bufferDepth.Load(), int64(0))
+	assert.Equal(t, stats.bufferBytes.Load(), int64(0))
+
+	_, err = b.peek()
+	assert.ErrorContains(t, err, "buffer empty")
+	assert.NilError(t, b.remove())
+}
+
+func TestDiskBufferLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		maxCount int
+		messages []string
+		// buffered is the number of messages accepted before the buffer is full
+		buffered int
+	}{
+		{name: "count limit", maxBytes: 1024, maxCount: 2, messages: []string{"a", "b", "c", "d"}, buffered: 2},
+		{name: "byte limit", maxBytes: 5, maxCount: 10, messages: []string{"aa", "bb", "cc"}, buffered: 2},
+		{name: "byte limit exactly reached", maxBytes: 6, maxCount: 10, messages: []string{"aa", "bb", "cc"}, buffered: 3},
+		{name: "message larger than limit", maxBytes: 1, maxCount: 10, messages: []string{"aa"}, buffered: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), loggerKey, zap.NewNop())
+			b, err := openDiskBuffer(t.TempDir(), tt.maxBytes, tt.maxCount)
+			assert.NilError(t, err)
+
+			buffered, dropped := stats.buffered.Load(), stats.dropped.Load()
+			var bytes int64
+			for i, msg := range tt.messages {
+				if i < tt.buffered {
+					bytes += int64(len(msg))
+				} else {
+					assert.ErrorIs(t, b.append([]byte(msg)), errBufferFull)
+				}
+				bufferMessage(ctx, b, []byte(msg))
+			}
+
+			want := len(tt.messages) - tt.buffered
+			assert.Equal(t, b.len(), tt.buffered)
+			assert.Equal(t, stats.buffered.Load()-buffered, uint64(tt.buffered))
+			assert.Equal(t, stats.dropped.Load()-dropped, uint64(want))
+			assert.Equal(t, stats.bufferDepth.Load(), int64(tt.buffered))
+			assert.Equal(t, stats.bufferBytes.Load(), bytes)
+
+			// the oldest messages are kept
+			for _, msg := range tt.messages[:tt.buffered] {
+				data, err := b.peek()
+				assert.NilError(t, err)
+				assert.Equal(t, string(data), msg)
+				assert.NilError(t, b.remove())
+			}
+		})
+	}
+}
+
+func TestDiskBufferRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := openDiskBuffer(dir, 1024, 100)
+	assert.NilError(t, err)
+	for i := 0; i < 12; i++ {
+		assert.NilError(t, b.append([]byte(fmt.Sprintf("message %d", i))))
+	}
+	assert.NilError(t, b.remove())
+
+	// restart
+	b, err = openDiskBuffer(dir, 1024, 100)
+	assert.NilError(t, err)
+	assert.Equal(t, b.len(), 11)
+	assert.Equal(t, stats.bufferDepth.Load(), int64(11))
+
+	// new messages are appended after the recovered ones
+	assert.NilError(t, b.append([]byte("message 12")))
+	for i := 1; i <= 12; i++ {
+		data, err := b.peek()
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), fmt.Sprintf("message %d", i))
+		assert.NilError(t, b.remove())
+	}
+	assert.Equal(t, b.len(), 0)
+}
+
+func TestDiskBufferStrayFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		fmt.Sprintf("%020d%s", 3, bufferFileExt):     "message 3",
+		fmt.Sprintf("%020d%s.tmp", 4, bufferFileExt): "incomplete",
+		fmt.Sprintf("%020d%s", 1, bufferFileExt):     "message 1",
+		"unrelated.txt":                              "unrelated",
+		"invalid" + bufferFileExt:                    "invalid",
+	}
+	for name, data := range files {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	assert.NilError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o755))
+
+	b, err := openDiskBuffer(dir, 1024, 100)
+	assert.NilError(t, err)
+	assert.Equal(t, b.len(), 2)
+	assert.Equal(t, b.bytes, int64(len("message 1")+len("message 3")))
+
+	// incomplete writes are removed, unknown files are left alone
+	_, err = os.Stat(filepath.Join(dir, fmt.Sprintf("%020d%s.tmp", 4, bufferFileExt)))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "unrelated.txt"))
+	assert.NilError(t, err)
+
+	// the sequence continues after the highest recovered message
+	assert.NilError(t, b.append([]byte("message 4")))
+	_, err = os.Stat(filepath.Join(dir, fmt.Sprintf("%020d%s", 4, bufferFileExt)))
+	assert.NilError(t, err)
+
+	for _, want := range []string{"message 1", "message 3", "message 4"} {
+		data, err := b.peek()
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), want)
+		assert.NilError(t, b.remove())
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -23,13 +24,38 @@ type loggerCtxKey string
 
 const (
 	loggerKey loggerCtxKey = "logger"
+
+	// modeJetStream publishes messages to a jetstream stream
+	modeJetStream = "jetstream"
+	// modeRequest sends core nats requests and measures the round-trip latency
+	modeRequest = "request"
 )
 
 type config struct {
-	NatsURL     string `envconfig:"NATS_SERVER" required:"true"`
-	Topic       string `envconfig:"NATS_TOPIC" required:"true"`
-	HealthZ     string `envconfig:"HEALTHZ_ADDRESS" default:":8080"`
-	HealthZPath string `envconfig:"HEALTHZ_PATH" default:"/healthz"`
+	NatsURL        string        `envconfig:"NATS_SERVER" required:"true"`
+	Topic          string        `envconfig:"NATS_TOPIC" required:"true"`
+	Mode           string        `envconfig:"MODE" default:"jetstream"`
+	RequestTimeout time.Duration `envconfig:"REQUEST_TIMEOUT" default:"2s"`
+	HealthZ        string        `envconfig:"HEALTHZ_ADDRESS" default:":8080"`
+	HealthZPath    string        `envconfig:"HEALTHZ_PATH" default:"/healthz"`
+	MetricsPath    string        `envconfig:"METRICS_PATH" default:"/metrics"`
+	// StreamReplicas is the number of replicas of the stream in a jetstream cluster
+	StreamReplicas int `envconfig:"STREAM_REPLICAS" default:"1"`
+
+	// publish retries and circuit breaker
+	MaxAttempts       int           `envconfig:"PUBLISH_MAX_ATTEMPTS" default:"3"`
+	InitialBackoff    time.Duration `envconfig:"PUBLISH_INITIAL_BACKOFF" default:"100ms"`
+	MaxBackoff        time.Duration `envconfig:"PUBLISH_MAX_BACKOFF" default:"2s"`
+	BackoffJitter     float64       `envconfig:"PUBLISH_BACKOFF_JITTER" default:"0.2"`
+	RetryNoResponders bool          `envconfig:"PUBLISH_RETRY_NO_RESPONDERS" default:"true"`
+	RetryTimeout      bool          `envconfig:"PUBLISH_RETRY_TIMEOUT" default:"true"`
+	BreakerThreshold  int           `envconfig:"BREAKER_FAILURE_THRESHOLD" default:"5"`
+	BreakerCooldown   time.Duration `envconfig:"BREAKER_COOLDOWN" default:"10s"`
+
+	// on-disk buffering of unsent messages, disabled if no directory is set
+	BufferDir         string `envconfig:"BUFFER_DIR"`
+	BufferMaxBytes    int64  `envconfig:"BUFFER_MAX_BYTES" default:"67108864"`
+	BufferMaxMessages int    `envconfig:"BUFFER_MAX_MESSAGES" default:"10000"`
 }
 
 var ready atomic.Bool
@@ -59,34 +85,105 @@ func main() {
 	if err = run(ctx, cfg); err != nil && !(errors.Is(err, context.Canceled) || errors.Is(err, http.ErrServerClosed)) {
 		logger.Fatal("could not run publisher", zap.Error(err))
 	}
-	logger.Info("shutdown complete")
+	// the final counters, e.g. to check for messages lost with the buffer of a replaced pod
+	logger.Info("shutdown complete", zap.Any("stats", stats.snapshot()))
 }
 
 func run(ctx context.Context, cfg config) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	if cfg.Mode != modeJetStream && cfg.Mode != modeRequest {
+		return fmt.Errorf("unsupported mode %q", cfg.Mode)
+	}
+
+	if cfg.MaxAttempts < 1 {
+		return fmt.Errorf("publish max attempts must be at least 1: %d", cfg.MaxAttempts)
+	}
+
+	if cfg.BackoffJitter < 0 || cfg.BackoffJitter > 1 {
+		return fmt.Errorf("publish backoff jitter must be between 0 and 1: %v", cfg.BackoffJitter)
+	}
+
+	if cfg.StreamReplicas < 1 || cfg.StreamReplicas > 5 {
+		return fmt.Errorf("stream replicas must be between 1 and 5: %d", cfg.StreamReplicas)
+	}
+
+	if cfg.BreakerThreshold < 0 {
+		return fmt.Errorf("circuit breaker failure threshold must not be negative: %d", cfg.BreakerThreshold)
+	}
+
 	eg, egCtx := errgroup.WithContext(ctx)
 
 	logger.Info(
 		"starting healthz handler",
 		zap.String("address", cfg.HealthZ),
 		zap.String("path", cfg.HealthZPath),
+		zap.String("metricsPath", cfg.MetricsPath),
 	)
 	eg.Go(func() error {
-		return runHealthZ(egCtx, cfg.HealthZ, cfg.HealthZPath)
+		return runHealthZ(egCtx, cfg.HealthZ, cfg.HealthZPath, cfg.MetricsPath)
 	})
 
-	logger.Info("starting nats jetstream message producer", zap.String("natsURL", cfg.NatsURL))
-	eg.Go(func() error {
-		return runPublisher(egCtx, cfg.NatsURL, cfg.Topic)
-	})
+	switch cfg.Mode {
+	case modeRequest:
+		logger.Info(
+			"starting nats requester",
+			zap.String("natsURL", cfg.NatsURL),
+			zap.Duration("timeout", cfg.RequestTimeout),
+		)
+		eg.Go(func() error {
+			return runRequester(egCtx, cfg.NatsURL, cfg.Topic, cfg.RequestTimeout)
+		})
+	default:
+		policy := retryPolicy{
+			maxAttempts:       cfg.MaxAttempts,
+			initialBackoff:    cfg.InitialBackoff,
+			maxBackoff:        cfg.MaxBackoff,
+			jitter:            cfg.BackoffJitter,
+			retryNoResponders: cfg.RetryNoResponders,
+			retryTimeout:      cfg.RetryTimeout,
+		}
+		breaker := newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+
+		var buffer *diskBuffer
+		if cfg.BufferDir != "" {
+			var err error
+			buffer, err = openDiskBuffer(cfg.BufferDir, cfg.BufferMaxBytes, cfg.BufferMaxMessages)
+			if err != nil {
+				return fmt.Errorf("could not open message buffer: %w", err)
+			}
+
+			logger.Info(
+				"buffering unsent messages on disk",
+				zap.String("directory", cfg.BufferDir),
+				zap.Int64("maxBytes", cfg.BufferMaxBytes),
+				zap.Int("maxMessages", cfg.BufferMaxMessages),
+				zap.Int("depth", buffer.len()),
+			)
+		}
+
+		logger.Info(
+			"starting nats jetstream message producer",
+			zap.String("natsURL", cfg.NatsURL),
+			zap.Int("streamReplicas", cfg.StreamReplicas),
+		)
+		eg.Go(func() error {
+			return runPublisher(egCtx, cfg.NatsURL, cfg.Topic, cfg.StreamReplicas, policy, breaker, buffer)
+		})
+	}
 
 	return eg.Wait()
 }
 
-func runPublisher(ctx context.Context, natsURL, topic string) error {
+// runPublisher publishes a message to topic every second to a stream with the given number of replicas. Failed
+// publishes are retried according to policy. Messages which still cannot be published, or which are produced while
+// the breaker is open, are written to buffer and replayed in order once publishing succeeds again. If buffer is nil,
+// these messages are dropped.
+func runPublisher(ctx context.Context, natsURL, topic string, replicas int, policy retryPolicy, breaker *circuitBreaker, buffer *diskBuffer) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
 
-	nc, err := nats.Connect(natsURL)
+	// keep reconnecting during longer outages so that buffered messages can be replayed
+	nc, err := nats.Connect(natsURL, nats.MaxReconnects(-1))
 	if err != nil {
 		return fmt.Errorf("could not connect to nats: %w", err)
 	}
@@ -99,6 +196,7 @@ func runPublisher(ctx context.Context, natsURL, topic string) error {
 	streamCfg := nats.StreamConfig{
 		Name:     "e2e",
 		Subjects: []string{topic},
+		Replicas: replicas,
 	}
 
 	logger.Info("creating nats stream", zap.String("topic", topic))
@@ -107,6 +205,36 @@ func runPublisher(ctx context.Context, natsURL, topic string) error {
 		return fmt.Errorf("could not create nats stream: %w", err)
 	}
 
+	// publish sends a single message honoring the circuit breaker and retry policy
+	publish := func(data []byte) error {
+		if !breaker.allow() {
+			return errBreakerOpen
+		}
+
+		var resp *nats.PubAck
+		err := policy.do(ctx, func() error {
+			var err error
+			resp, err = js.Publish(topic, data)
+			if err != nil {
+				stats.failed.Add(1)
+			}
+			return err
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				breaker.failure()
+			}
+			ready.Store(false)
+			return err
+		}
+
+		logger.Info("successfully published message", zap.Uint64("sequenceID", resp.Sequence))
+		stats.published.Add(1)
+		breaker.success()
+		ready.Store(true)
+		return nil
+	}
+
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
@@ -117,21 +245,68 @@ func runPublisher(ctx context.Context, natsURL, topic string) error {
 			logger.Info("shutting down publisher", zap.Any("cause", ctx.Err()))
 			return nil
 		case <-ticker.C:
-			msg := fmt.Sprintf("test message: %d @%s", counter, time.Now().UTC().String())
-			resp, err := js.Publish(topic, []byte(msg))
-			if err != nil {
-				logger.Error("could not publish message", zap.Error(err))
-				ready.Store(false)
+			msg := []byte(fmt.Sprintf("test message: %d @%s", counter, time.Now().UTC().String()))
+			counter++
+			stats.produced.Add(1)
+
+			// preserve order: new messages queue up behind previously buffered ones
+			if buffer != nil && buffer.len() > 0 {
+				bufferMessage(ctx, buffer, msg)
+				if err := replayBuffer(ctx, buffer, publish); err != nil && ctx.Err() == nil {
+					logger.Warn("could not replay buffered messages", zap.Error(err), zap.Int("depth", buffer.len()))
+				}
 				continue
 			}
-			logger.Info("successfully published message", zap.Uint64("sequenceID", resp.Sequence))
-			ready.Store(true)
-			counter++
+
+			err := publish(msg)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+
+			if buffer != nil {
+				logger.Warn("could not publish message, buffering message", zap.Error(err), zap.ByteString("data", msg))
+				bufferMessage(ctx, buffer, msg)
+				continue
+			}
+
+			logger.Error("could not publish message, dropping message", zap.Error(err), zap.ByteString("data", msg))
+			stats.dropped.Add(1)
+		}
+	}
+}
+
+// bufferMessage appends msg to buffer or drops it if it cannot be buffered
+func bufferMessage(ctx context.Context, buffer *diskBuffer, msg []byte) {
+	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	if err := buffer.append(msg); err != nil {
+		logger.Error("could not buffer message, dropping message", zap.Error(err), zap.ByteString("data", msg))
+		stats.dropped.Add(1)
+	}
+}
+
+// replayBuffer publishes buffered messages in order until the buffer is empty or a publish fails
+func replayBuffer(ctx context.Context, buffer *diskBuffer, publish func([]byte) error) error {
+	for buffer.len() > 0 && ctx.Err() == nil {
+		data, err := buffer.peek()
+		if err != nil {
+			return err
 		}
+
+		if err = publish(data); err != nil {
+			return err
+		}
+
+		if err = buffer.remove(); err != nil {
+			return err
+		}
+		stats.replayed.Add(1)
 	}
+
+	return nil
 }
 
-func runHealthZ(ctx context.Context, address, path string) error {
+func runHealthZ(ctx context.Context, address, path, metricsPath string) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
 
 	router := httprouter.New()
@@ -143,6 +318,12 @@ func runHealthZ(ctx context.Context, address, path string) error {
 
 		w.WriteHeader(http.StatusOK)
 	})
+	router.GET(metricsPath, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats.snapshot()); err != nil {
+			logger.Error("could not encode metrics", zap.Error(err))
+		}
+	})
 
 	srv := http.Server{
 		Addr:         address,
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats holds the publisher counters exposed on the metrics endpoint
+var stats metrics
+
+type metrics struct {
+	// messages created by the jetstream publisher, i.e. published, buffered or dropped
+	produced  atomic.Uint64
+	published atomic.Uint64
+	// failed publish attempts, including retried ones
+	failed atomic.Uint64
+	// retries of failed publish attempts
+	retries atomic.Uint64
+	// messages which were given up on
+	dropped atomic.Uint64
+
+	// messages written to and replayed from the on-disk buffer
+	buffered    atomic.Uint64
+	replayed    atomic.Uint64
+	bufferDepth atomic.Int64
+	bufferBytes atomic.Int64
+
+	breakerState atomic.Value // string
+	breakerTrips atomic.Uint64
+
+	// request-reply mode
+	requests     atomic.Uint64
+	replies      atomic.Uint64
+	errorReplies atomic.Uint64
+	timeouts     atomic.Uint64
+	latency      latency
+}
+
+// latency records round-trip times of successful requests
+type latency struct {
+	sync.Mutex
+	count uint64
+	total time.Duration
+	min   time.Duration
+	max   time.Duration
+	last  time.Duration
+}
+
+func (l *latency) record(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.count == 0 || d < l.min {
+		l.min = d
+	}
+	if d > l.max {
+		l.max = d
+	}
+	l.count++
+	l.total += d
+	l.last = d
+}
+
+type latencySnapshot struct {
+	Count   uint64  `json:"count"`
+	MinMs   float64 `json:"minMs"`
+	MaxMs   float64 `json:"maxMs"`
+	AvgMs   float64 `json:"avgMs"`
+	LastMs  float64 `json:"lastMs"`
+	TotalMs float64 `json:"totalMs"`
+}
+
+func (l *latency) snapshot() latencySnapshot {
+	l.Lock()
+	defer l.Unlock()
+
+	s := latencySnapshot{
+		Count:   l.count,
+		MinMs:   millis(l.min),
+		MaxMs:   millis(l.max),
+		LastMs:  millis(l.last),
+		TotalMs: millis(l.total),
+	}
+	if l.count > 0 {
+		s.AvgMs = millis(l.total / time.Duration(l.count))
+	}
+	return s
+}
+
+type metricsSnapshot struct {
+	Produced     uint64          `json:"produced"`
+	Published    uint64          `json:"published"`
+	Failed       uint64          `json:"failed"`
+	Retries      uint64          `json:"retries"`
+	Dropped      uint64          `json:"dropped"`
+	Buffered     uint64          `json:"buffered"`
+	Replayed     uint64          `json:"replayed"`
+	BufferDepth  int64           `json:"bufferDepth"`
+	BufferBytes  int64           `json:"bufferBytes"`
+	BreakerState string          `json:"breakerState,omitempty"`
+	BreakerTrips uint64          `json:"breakerTrips"`
+	Requests     uint64          `json:"requests"`
+	Replies      uint64          `json:"replies"`
+	ErrorReplies uint64          `json:"errorReplies"`
+	Timeouts     uint64          `json:"timeouts"`
+	Latency      latencySnapshot `json:"latency"`
+}
+
+func (m *metrics) snapshot() metricsSnapshot {
+	state, _ := m.breakerState.Load().(string)
+
+	return metricsSnapshot{
+		Produced:     m.produced.Load(),
+		Published:    m.published.Load(),
+		Failed:       m.failed.Load(),
+		Retries:      m.retries.Load(),
+		Dropped:      m.dropped.Load(),
+		Buffered:     m.buffered.Load(),
+		Replayed:     m.replayed.Load(),
+		BufferDepth:  m.bufferDepth.Load(),
+		BufferBytes:  m.bufferBytes.Load(),
+		BreakerState: state,
+		BreakerTrips: m.breakerTrips.Load(),
+		Requests:     m.requests.Load(),
+		Replies:      m.replies.Load(),
+		ErrorReplies: m.errorReplies.Load(),
+		Timeouts:     m.timeouts.Load(),
+		Latency:      m.latency.snapshot(),
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// headers set by the subscriber when it replies with an (injected) error
+	serviceErrorHeader     = "Nats-Service-Error"
+	serviceErrorCodeHeader = "Nats-Service-Error-Code"
+)
+
+// runRequester sends a request on subject every second and records the round-trip latency of the replies. Requests
+// which are not answered within timeout are counted as timeouts.
+func runRequester(ctx context.Context, natsURL, subject string, timeout time.Duration) error {
+	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("could not connect to nats: %w", err)
+	}
+	defer nc.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	counter := 0
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("shutting down requester", zap.Any("cause", ctx.Err()))
+			return nil
+		case <-ticker.C:
+			msg := fmt.Sprintf("test request: %d @%s", counter, time.Now().UTC().String())
+			counter++
+
+			stats.requests.Add(1)
+			start := time.Now()
+			resp, err := request(ctx, nc, subject, []byte(msg), timeout)
+			rtt := time.Since(start)
+
+			if err != nil {
+				ready.Store(false)
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+					stats.timeouts.Add(1)
+					logger.Error("request timed out", zap.Duration("timeout", timeout))
+					continue
+				}
+
+				stats.failed.Add(1)
+				logger.Error("could not send request", zap.Error(err))
+				continue
+			}
+
+			stats.latency.record(rtt)
+			if e := resp.Header.Get(serviceErrorHeader); e != "" {
+				ready.Store(false)
+				stats.errorReplies.Add(1)
+				logger.Error(
+					"received error reply",
+					zap.String("error", e),
+					zap.String("code", resp.Header.Get(serviceErrorCodeHeader)),
+					zap.Duration("rtt", rtt),
+				)
+				continue
+			}
+
+			stats.replies.Add(1)
+			logger.Info("successfully received reply", zap.String("data", string(resp.Data)), zap.Duration("rtt", rtt))
+			ready.Store(true)
+		}
+	}
+}
+
+func request(ctx context.Context, nc *nats.Conn, subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return nc.RequestWithContext(ctx, subject, data)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// retryPolicy controls how often and how fast a failed publish is retried
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// jitter randomizes each backoff by +/- the given fraction, e.g. 0.2 for +/- 20%
+	jitter float64
+
+	retryNoResponders bool
+	retryTimeout      bool
+}
+
+// retryable classifies the given publish error. No responders (stream unavailable, e.g. during leader election) and
+// timeouts are transient and retried if enabled in the policy. Everything else, e.g. invalid subjects or payload
+// limits, will not succeed on retry.
+func (p retryPolicy) retryable(err error) bool {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders), errors.Is(err, nats.ErrNoStreamResponse):
+		return p.retryNoResponders
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return p.retryTimeout
+	case errors.Is(err, nats.ErrConnectionReconnecting), errors.Is(err, nats.ErrDisconnected):
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the time to wait before the given retry (starting at 1)
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.initialBackoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+
+	if p.jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// do invokes fn until it succeeds, returns a non-retryable error or the maximum number of attempts is reached
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= p.maxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		logger.Warn("retrying failed publish", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("backoff", wait))
+		stats.retries.Add(1)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// circuitBreaker stops publishing after threshold consecutive failures. After cooldown a single message is let
+// through to probe whether the server recovered. Further messages are rejected until the probe succeeded, which closes
+// the breaker, or failed, which opens it again.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// probing is set while the probe of the half-open breaker is in flight
+	probing bool
+}
+
+// newCircuitBreaker returns a closed circuit breaker. A threshold of 0 disables the breaker.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	stats.breakerState.Store(string(breakerClosed))
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// allow reports whether a message may be published
+func (b *circuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.setState(breakerClosed)
+}
+
+func (b *circuitBreaker) failure() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.threshold == 0 {
+		return
+	}
+
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != breakerOpen {
+			stats.breakerTrips.Add(1)
+		}
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	b.state = s
+	stats.breakerState.Store(string(s))
+}
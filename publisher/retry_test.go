@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gotest.tools/v3/assert"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		err    error
+		want   bool
+	}{
+		{name: "no responders enabled", policy: retryPolicy{retryNoResponders: true}, err: nats.ErrNoResponders, want: true},
+		{name: "no responders disabled", policy: retryPolicy{}, err: nats.ErrNoResponders, want: false},
+		{name: "no stream response enabled", policy: retryPolicy{retryNoResponders: true}, err: nats.ErrNoStreamResponse, want: true},
+		{name: "timeout enabled", policy: retryPolicy{retryTimeout: true}, err: nats.ErrTimeout, want: true},
+		{name: "timeout disabled", policy: retryPolicy{}, err: nats.ErrTimeout, want: false},
+		{name: "deadline exceeded enabled", policy: retryPolicy{retryTimeout: true}, err: context.DeadlineExceeded, want: true},
+		{name: "wrapped timeout", policy: retryPolicy{retryTimeout: true}, err: fmt.Errorf("publish: %w", nats.ErrTimeout), want: true},
+		{name: "reconnecting", policy: retryPolicy{}, err: nats.ErrConnectionReconnecting, want: true},
+		{name: "disconnected", policy: retryPolicy{}, err: nats.ErrDisconnected, want: true},
+		{name: "invalid subject", policy: retryPolicy{retryNoResponders: true, retryTimeout: true}, err: nats.ErrBadSubject, want: false},
+		{name: "max payload", policy: retryPolicy{retryNoResponders: true, retryTimeout: true}, err: nats.ErrMaxPayload, want: false},
+		{name: "other error", policy: retryPolicy{retryNoResponders: true, retryTimeout: true}, err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.policy.retryable(tt.err), tt.want)
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 100 * time.Millisecond},
+		{retry: 2, want: 200 * time.Millisecond},
+		{retry: 3, want: 400 * time.Millisecond},
+		{retry: 4, want: 800 * time.Millisecond},
+		{retry: 5, want: time.Second},
+		{retry: 50, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("retry %d", tt.retry), func(t *testing.T) {
+			assert.Equal(t, policy.backoff(tt.retry), tt.want)
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := retryPolicy{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second, jitter: 0.2}
+
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{retry: 1, min: 80 * time.Millisecond, max: 120 * time.Millisecond},
+		{retry: 3, min: 320 * time.Millisecond, max: 480 * time.Millisecond},
+		{retry: 10, min: 800 * time.Millisecond, max: 1200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("retry %d", tt.retry), func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				d := policy.backoff(tt.retry)
+				assert.Assert(t, d >= tt.min && d <= tt.max, "backoff %s not in [%s, %s]", d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		// op is one of allow, success or failure
+		op string
+		// allowed is the expected result of allow
+		allowed bool
+		state   breakerState
+	}
+
+	tests := []struct {
+		name      string
+		threshold int
+		cooldown  time.Duration
+		steps     []step
+	}{
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{op: "failure", state: breakerClosed},
+				{op: "failure", state: breakerClosed},
+				{op: "allow", allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name:      "opens after threshold",
+			threshold: 2,
+			cooldown:  time.Hour,
+			steps: []step{
+				{op: "allow", allowed: true, state: breakerClosed},
+				{op: "failure", state: breakerClosed},
+				{op: "allow", allowed: true, state: breakerClosed},
+				{op: "failure", state: breakerOpen},
+				{op: "allow", allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name:      "success resets failures",
+			threshold: 2,
+			cooldown:  time.Hour,
+			steps: []step{
+				{op: "failure", state: breakerClosed},
+				{op: "success", state: breakerClosed},
+				{op: "failure", state: breakerClosed},
+				{op: "allow", allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name:      "single probe after cooldown",
+			threshold: 1,
+			steps: []step{
+				{op: "failure", state: breakerOpen},
+				{op: "allow", allowed: true, state: breakerHalfOpen},
+				{op: "allow", allowed: false, state: breakerHalfOpen},
+				{op: "allow", allowed: false, state: breakerHalfOpen},
+			},
+		},
+		{
+			name:      "successful probe closes",
+			threshold: 1,
+			steps: []step{
+				{op: "failure", state: breakerOpen},
+				{op: "allow", allowed: true, state: breakerHalfOpen},
+				{op: "success", state: breakerClosed},
+				{op: "allow", allowed: true, state: breakerClosed},
+				{op: "allow", allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name:      "failed probe reopens",
+			threshold: 3,
+			steps: []step{
+				{op: "failure", state: breakerClosed},
+				{op: "failure", state: breakerClosed},
+				{op: "failure", state: breakerOpen},
+				{op: "allow", allowed: true, state: breakerHalfOpen},
+				{op: "failure", state: breakerOpen},
+				{op: "allow", allowed: true, state: breakerHalfOpen},
+				{op: "allow", allowed: false, state: breakerHalfOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(tt.threshold, tt.cooldown)
+			for i, s := range tt.steps {
+				switch s.op {
+				case "allow":
+					assert.Equal(t, b.allow(), s.allowed, "step %d", i)
+				case "success":
+					b.success()
+				case "failure":
+					b.failure()
+				default:
+					t.Fatalf("unknown op %q", s.op)
+				}
+				assert.Equal(t, b.state, s.state, "step %d", i)
+				assert.Equal(t, stats.breakerState.Load(), string(s.state), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	b := newCircuitBreaker(1, 0)
+	trips := stats.breakerTrips.Load()
+
+	b.failure()
+	assert.Equal(t, stats.breakerTrips.Load(), trips+1)
+
+	// a failed probe trips the breaker again
+	assert.Assert(t, b.allow())
+	b.failure()
+	assert.Equal(t, stats.breakerTrips.Load(), trips+2)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	deliverAll             = "all"
+	deliverLast            = "last"
+	deliverNew             = "new"
+	deliverByStartSequence = "by_start_sequence"
+	deliverByStartTime     = "by_start_time"
+	deliverLastPerSubject  = "last_per_subject"
+
+	replayInstant  = "instant"
+	replayOriginal = "original"
+)
+
+// consumerOptions returns the jetstream subscription options for the configured deliver and replay policy and
+// durable name
+func consumerOptions(cfg config) ([]nats.SubOpt, error) {
+	cc, err := consumerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []nats.SubOpt
+	if cc.Durable != "" {
+		opts = append(opts, nats.Durable(cc.Durable))
+	}
+
+	switch cc.DeliverPolicy {
+	case nats.DeliverAllPolicy:
+		opts = append(opts, nats.DeliverAll())
+	case nats.DeliverLastPolicy:
+		opts = append(opts, nats.DeliverLast())
+	case nats.DeliverNewPolicy:
+		opts = append(opts, nats.DeliverNew())
+	case nats.DeliverByStartSequencePolicy:
+		opts = append(opts, nats.StartSequence(cc.OptStartSeq))
+	case nats.DeliverByStartTimePolicy:
+		opts = append(opts, nats.StartTime(*cc.OptStartTime))
+	case nats.DeliverLastPerSubjectPolicy:
+		opts = append(opts, nats.DeliverLastPerSubject())
+	}
+
+	switch cc.ReplayPolicy {
+	case nats.ReplayInstantPolicy:
+		opts = append(opts, nats.ReplayInstant())
+	case nats.ReplayOriginalPolicy:
+		opts = append(opts, nats.ReplayOriginal())
+	}
+
+	return opts, nil
+}
+
+// consumerConfig maps the configured deliver and replay policy and durable name to a jetstream consumer config
+func consumerConfig(cfg config) (nats.ConsumerConfig, error) {
+	cc := nats.ConsumerConfig{Durable: cfg.Durable}
+
+	switch cfg.DeliverPolicy {
+	case deliverAll:
+		cc.DeliverPolicy = nats.DeliverAllPolicy
+	case deliverLast:
+		cc.DeliverPolicy = nats.DeliverLastPolicy
+	case deliverNew:
+		cc.DeliverPolicy = nats.DeliverNewPolicy
+	case deliverByStartSequence:
+		if cfg.DeliverStartSequence == 0 {
+			return cc, fmt.Errorf("deliver policy %q requires a start sequence", cfg.DeliverPolicy)
+		}
+		cc.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		cc.OptStartSeq = cfg.DeliverStartSequence
+	case deliverByStartTime:
+		if cfg.DeliverStartTime.IsZero() {
+			return cc, fmt.Errorf("deliver policy %q requires a start time", cfg.DeliverPolicy)
+		}
+		startTime := cfg.DeliverStartTime
+		cc.DeliverPolicy = nats.DeliverByStartTimePolicy
+		cc.OptStartTime = &startTime
+	case deliverLastPerSubject:
+		cc.DeliverPolicy = nats.DeliverLastPerSubjectPolicy
+	default:
+		return cc, fmt.Errorf("unsupported deliver policy %q", cfg.DeliverPolicy)
+	}
+
+	switch cfg.ReplayPolicy {
+	case replayInstant:
+		cc.ReplayPolicy = nats.ReplayInstantPolicy
+	case replayOriginal:
+		cc.ReplayPolicy = nats.ReplayOriginalPolicy
+	default:
+		return cc, fmt.Errorf("unsupported replay policy %q", cfg.ReplayPolicy)
+	}
+
+	return cc, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gotest.tools/v3/assert"
+)
+
+func TestConsumerOptions(t *testing.T) {
+	start := time.Date(2023, 5, 4, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		cfg     config
+		want    nats.ConsumerConfig
+		opts    int
+		wantErr string
+	}{
+		{
+			name: "deliver all",
+			cfg:  config{DeliverPolicy: deliverAll, ReplayPolicy: replayInstant},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverAllPolicy, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 2,
+		},
+		{
+			name: "deliver last",
+			cfg:  config{DeliverPolicy: deliverLast, ReplayPolicy: replayInstant},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverLastPolicy, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 2,
+		},
+		{
+			name: "deliver new",
+			cfg:  config{DeliverPolicy: deliverNew, ReplayPolicy: replayInstant},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverNewPolicy, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 2,
+		},
+		{
+			name: "deliver by start sequence",
+			cfg:  config{DeliverPolicy: deliverByStartSequence, DeliverStartSequence: 42, ReplayPolicy: replayInstant},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverByStartSequencePolicy, OptStartSeq: 42, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 2,
+		},
+		{
+			name: "deliver by start time",
+			cfg:  config{DeliverPolicy: deliverByStartTime, DeliverStartTime: start, ReplayPolicy: replayOriginal},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverByStartTimePolicy, OptStartTime: &start, ReplayPolicy: nats.ReplayOriginalPolicy},
+			opts: 2,
+		},
+		{
+			name: "deliver last per subject",
+			cfg:  config{DeliverPolicy: deliverLastPerSubject, ReplayPolicy: replayInstant},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverLastPerSubjectPolicy, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 2,
+		},
+		{
+			name: "replay original",
+			cfg:  config{DeliverPolicy: deliverAll, ReplayPolicy: replayOriginal},
+			want: nats.ConsumerConfig{DeliverPolicy: nats.DeliverAllPolicy, ReplayPolicy: nats.ReplayOriginalPolicy},
+			opts: 2,
+		},
+		{
+			name: "durable",
+			cfg:  config{DeliverPolicy: deliverAll, ReplayPolicy: replayInstant, Durable: "e2e-subscriber"},
+			want: nats.ConsumerConfig{Durable: "e2e-subscriber", DeliverPolicy: nats.DeliverAllPolicy, ReplayPolicy: nats.ReplayInstantPolicy},
+			opts: 3,
+		},
+		{
+			name:    "start sequence missing",
+			cfg:     config{DeliverPolicy: deliverByStartSequence, ReplayPolicy: replayInstant},
+			wantErr: `deliver policy "by_start_sequence" requires a start sequence`,
+		},
+		{
+			name:    "start time missing",
+			cfg:     config{DeliverPolicy: deliverByStartTime, ReplayPolicy: replayInstant},
+			wantErr: `deliver policy "by_start_time" requires a start time`,
+		},
+		{
+			name:    "unsupported deliver policy",
+			cfg:     config{DeliverPolicy: "first", ReplayPolicy: replayInstant},
+			wantErr: `unsupported deliver policy "first"`,
+		},
+		{
+			name:    "empty deliver policy",
+			cfg:     config{ReplayPolicy: replayInstant},
+			wantErr: `unsupported deliver policy ""`,
+		},
+		{
+			name:    "unsupported replay policy",
+			cfg:     config{DeliverPolicy: deliverAll, ReplayPolicy: "fast"},
+			wantErr: `unsupported replay policy "fast"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc, err := consumerConfig(tt.cfg)
+			opts, optsErr := consumerOptions(tt.cfg)
+
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				assert.Error(t, optsErr, tt.wantErr)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.NilError(t, optsErr)
+			assert.DeepEqual(t, cc, tt.want)
+			assert.Equal(t, len(opts), tt.opts)
+		})
+	}
+}
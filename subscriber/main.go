@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -23,13 +24,42 @@ type loggerCtxKey string
 
 const (
 	loggerKey loggerCtxKey = "logger"
+
+	// modeJetStream consumes messages from a jetstream stream
+	modeJetStream = "jetstream"
+	// modeReply replies to core nats requests
+	modeReply = "reply"
 )
 
 type config struct {
-	NatsURL     string `envconfig:"NATS_SERVER" required:"true"`
-	Topic       string `envconfig:"NATS_TOPIC" required:"true"`
-	HealthZ     string `envconfig:"HEALTHZ_ADDRESS" default:":8080"`
-	HealthZPath string `envconfig:"HEALTHZ_PATH" default:"/healthz"`
+	NatsURL        string        `envconfig:"NATS_SERVER" required:"true"`
+	Topic          string        `envconfig:"NATS_TOPIC" required:"true"`
+	Mode           string        `envconfig:"MODE" default:"jetstream"`
+	ReplyDelay     time.Duration `envconfig:"REPLY_DELAY" default:"0s"`
+	ReplyErrorRate float64       `envconfig:"REPLY_ERROR_RATE" default:"0"`
+	// ReplyWorkers is the number of requests handled concurrently by the responder
+	ReplyWorkers int    `envconfig:"REPLY_WORKERS" default:"32"`
+	HealthZ      string `envconfig:"HEALTHZ_ADDRESS" default:":8080"`
+	HealthZPath  string `envconfig:"HEALTHZ_PATH" default:"/healthz"`
+	MetricsPath  string `envconfig:"METRICS_PATH" default:"/metrics"`
+
+	// jetstream consumer, e.g. to replay historical messages
+	DeliverPolicy        string    `envconfig:"DELIVER_POLICY" default:"all"`
+	DeliverStartSequence uint64    `envconfig:"DELIVER_START_SEQUENCE"`
+	DeliverStartTime     time.Time `envconfig:"DELIVER_START_TIME"`
+	ReplayPolicy         string    `envconfig:"REPLAY_POLICY" default:"instant"`
+	// Durable is the name of a durable consumer which survives subscriber restarts, ephemeral if empty
+	Durable string `envconfig:"CONSUMER_DURABLE"`
+	// Queue is the deliver group of the consumer, replicas in the same group share its messages
+	Queue string `envconfig:"CONSUMER_QUEUE"`
+
+	// outputs for received messages
+	Sinks              []string      `envconfig:"SINKS" default:"log"`
+	SinkFileDir        string        `envconfig:"SINK_FILE_DIR"`
+	SinkFileMaxBytes   int64         `envconfig:"SINK_FILE_MAX_BYTES" default:"10485760"`
+	SinkFileMaxBackups int           `envconfig:"SINK_FILE_MAX_BACKUPS" default:"5"`
+	SinkWebhookURL     string        `envconfig:"SINK_WEBHOOK_URL"`
+	SinkWebhookTimeout time.Duration `envconfig:"SINK_WEBHOOK_TIMEOUT" default:"5s"`
 }
 
 var ready atomic.Bool
@@ -64,29 +94,84 @@ func main() {
 
 func run(ctx context.Context, cfg config) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	if cfg.Mode != modeJetStream && cfg.Mode != modeReply {
+		return fmt.Errorf("unsupported mode %q", cfg.Mode)
+	}
+
+	if cfg.ReplyErrorRate < 0 || cfg.ReplyErrorRate > 1 {
+		return fmt.Errorf("reply error rate must be between 0 and 1: %v", cfg.ReplyErrorRate)
+	}
+
+	if cfg.ReplyWorkers < 1 {
+		return fmt.Errorf("reply workers must be at least 1: %d", cfg.ReplyWorkers)
+	}
+
 	eg, egCtx := errgroup.WithContext(ctx)
 
 	logger.Info(
 		"starting healthz handler",
 		zap.String("address", cfg.HealthZ),
 		zap.String("path", cfg.HealthZPath),
+		zap.String("metricsPath", cfg.MetricsPath),
 	)
 	eg.Go(func() error {
-		return runHealthZ(egCtx, cfg.HealthZ, cfg.HealthZPath)
+		return runHealthZ(egCtx, cfg.HealthZ, cfg.HealthZPath, cfg.MetricsPath)
 	})
 
-	logger.Info("starting nats jetstream message producer", zap.String("natsURL", cfg.NatsURL))
-	eg.Go(func() error {
-		return runSubscriber(egCtx, cfg.NatsURL, cfg.Topic)
-	})
+	switch cfg.Mode {
+	case modeReply:
+		logger.Info(
+			"starting nats responder",
+			zap.String("natsURL", cfg.NatsURL),
+			zap.Duration("delay", cfg.ReplyDelay),
+			zap.Float64("errorRate", cfg.ReplyErrorRate),
+			zap.Int("workers", cfg.ReplyWorkers),
+		)
+		eg.Go(func() error {
+			return runResponder(egCtx, cfg.NatsURL, cfg.Topic, cfg.ReplyDelay, cfg.ReplyErrorRate, cfg.ReplyWorkers)
+		})
+	default:
+		opts, err := consumerOptions(cfg)
+		if err != nil {
+			return fmt.Errorf("could not configure consumer: %w", err)
+		}
+
+		out, err := newSink(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("could not create sink: %w", err)
+		}
+		defer func() {
+			if err := out.Close(); err != nil {
+				logger.Error("could not close sink", zap.Error(err))
+			}
+		}()
+
+		logger.Info(
+			"starting nats jetstream message producer",
+			zap.String("natsURL", cfg.NatsURL),
+			zap.Strings("sinks", cfg.Sinks),
+			zap.String("deliverPolicy", cfg.DeliverPolicy),
+			zap.String("replayPolicy", cfg.ReplayPolicy),
+			zap.String("durable", cfg.Durable),
+			zap.String("queue", cfg.Queue),
+		)
+		eg.Go(func() error {
+			return runSubscriber(egCtx, cfg.NatsURL, cfg.Topic, cfg.Queue, out, opts...)
+		})
+	}
 
 	return eg.Wait()
 }
 
-func runSubscriber(ctx context.Context, natsURL, topic string) error {
+// runSubscriber consumes messages from topic and writes them to out. Messages which cannot be written are negatively
+// acknowledged for redelivery. The given options configure the jetstream consumer. If queue is set, the consumer
+// delivers every message to one of the subscribers of the queue group.
+func runSubscriber(ctx context.Context, natsURL, topic, queue string, out sink, opts ...nats.SubOpt) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
 
-	nc, err := nats.Connect(natsURL)
+	// keep reconnecting, e.g. while a server of a jetstream cluster restarts
+	nc, err := nats.Connect(natsURL, nats.MaxReconnects(-1))
 	if err != nil {
 		return fmt.Errorf("could not connect to nats: %w", err)
 	}
@@ -99,19 +184,39 @@ func runSubscriber(ctx context.Context, natsURL, topic string) error {
 		md, err := msg.Metadata()
 		if err != nil {
 			ready.Store(false)
+			stats.failed.Add(1)
 			logger.Error("unexpected nats message without metadata", zap.Error(err))
 			return
 		}
 
-		logger.Info(
-			"received nats message",
-			zap.String("data", string(msg.Data)),
-			zap.Any("sequence", md.Sequence),
-		)
+		r := record{
+			Subject:          msg.Subject,
+			StreamSequence:   md.Sequence.Stream,
+			ConsumerSequence: md.Sequence.Consumer,
+			Timestamp:        md.Timestamp,
+			Received:         time.Now(),
+			Data:             string(msg.Data),
+		}
+
+		if err = out.Write(ctx, r); err != nil {
+			ready.Store(false)
+			stats.sinkErrors.Add(1)
+			logger.Error("could not write message to sink", zap.Error(err), zap.Uint64("sequence", r.StreamSequence))
+			if err = msg.Nak(); err != nil {
+				logger.Error("could not negatively acknowledge message", zap.Error(err))
+			}
+			return
+		}
+
+		stats.received.Add(1)
 		ready.Store(true)
 	}
 
-	_, err = js.Subscribe(topic, handler)
+	if queue != "" {
+		_, err = js.QueueSubscribe(topic, queue, handler, opts...)
+	} else {
+		_, err = js.Subscribe(topic, handler, opts...)
+	}
 	if err != nil {
 		return fmt.Errorf("could not subscribe to nats stream: %w", err)
 	}
@@ -121,7 +226,7 @@ func runSubscriber(ctx context.Context, natsURL, topic string) error {
 	return nil
 }
 
-func runHealthZ(ctx context.Context, address, path string) error {
+func runHealthZ(ctx context.Context, address, path, metricsPath string) error {
 	logger := ctx.Value(loggerKey).(*zap.Logger)
 
 	router := httprouter.New()
@@ -133,6 +238,12 @@ func runHealthZ(ctx context.Context, address, path string) error {
 
 		w.WriteHeader(http.StatusOK)
 	})
+	router.GET(metricsPath, func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats.snapshot()); err != nil {
+			logger.Error("could not encode metrics", zap.Error(err))
+		}
+	})
 
 	srv := http.Server{
 		Addr:         address,
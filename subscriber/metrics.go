@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// stats holds the subscriber counters exposed on the metrics endpoint
+var stats metrics
+
+type metrics struct {
+	received   atomic.Uint64
+	failed     atomic.Uint64
+	sinkErrors atomic.Uint64
+
+	// request-reply mode
+	requests     atomic.Uint64
+	replies      atomic.Uint64
+	errorReplies atomic.Uint64
+}
+
+type metricsSnapshot struct {
+	Received     uint64 `json:"received"`
+	Failed       uint64 `json:"failed"`
+	SinkErrors   uint64 `json:"sinkErrors"`
+	Requests     uint64 `json:"requests"`
+	Replies      uint64 `json:"replies"`
+	ErrorReplies uint64 `json:"errorReplies"`
+}
+
+func (m *metrics) snapshot() metricsSnapshot {
+	return metricsSnapshot{
+		Received:     m.received.Load(),
+		Failed:       m.failed.Load(),
+		SinkErrors:   m.sinkErrors.Load(),
+		Requests:     m.requests.Load(),
+		Replies:      m.replies.Load(),
+		ErrorReplies: m.errorReplies.Load(),
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// queue group shared by all responder replicas
+	responderQueue = "e2e-responders"
+
+	// headers set when replying with an (injected) error
+	serviceErrorHeader     = "Nats-Service-Error"
+	serviceErrorCodeHeader = "Nats-Service-Error-Code"
+)
+
+// runResponder replies to core nats requests on subject. Each reply is delayed by delay and answered with an error
+// with the probability of errorRate. Up to workers requests are handled concurrently, so the delay of one request does
+// not hold up the requests queued behind it.
+func runResponder(ctx context.Context, natsURL, subject string, delay time.Duration, errorRate float64, workers int) error {
+	logger := ctx.Value(loggerKey).(*zap.Logger)
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		return fmt.Errorf("could not connect to nats: %w", err)
+	}
+	defer nc.Close()
+
+	respond := func(msg *nats.Msg) {
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		reply := nats.NewMsg(msg.Reply)
+		if errorRate > 0 && rand.Float64() < errorRate {
+			reply.Header.Set(serviceErrorHeader, "injected error")
+			reply.Header.Set(serviceErrorCodeHeader, "500")
+			if err := msg.RespondMsg(reply); err != nil {
+				logger.Error("could not send error reply", zap.Error(err))
+				return
+			}
+
+			stats.errorReplies.Add(1)
+			logger.Info("sent injected error reply", zap.String("data", string(msg.Data)))
+			return
+		}
+
+		reply.Data = []byte("reply to " + string(msg.Data))
+		if err := msg.RespondMsg(reply); err != nil {
+			ready.Store(false)
+			logger.Error("could not send reply", zap.Error(err))
+			return
+		}
+
+		stats.replies.Add(1)
+		logger.Info("sent reply", zap.String("data", string(msg.Data)))
+		ready.Store(true)
+	}
+
+	requests := make(chan *nats.Msg)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-requests:
+					respond(msg)
+				}
+			}
+		}()
+	}
+
+	// the callback only blocks while all workers are busy, further requests stay pending in the subscription
+	handler := func(msg *nats.Msg) {
+		stats.requests.Add(1)
+
+		select {
+		case <-ctx.Done():
+		case requests <- msg:
+		}
+	}
+
+	_, err = nc.QueueSubscribe(subject, responderQueue, handler)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to nats subject: %w", err)
+	}
+
+	<-ctx.Done()
+	logger.Info("shutting down responder", zap.Any("cause", ctx.Err()))
+	return nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	sinkLog     = "log"
+	sinkStdout  = "stdout"
+	sinkFile    = "file"
+	sinkWebhook = "webhook"
+
+	sinkFileName = "messages.jsonl"
+
+	// maxPartialWrites is the number of partially written records tracked by multiSink
+	maxPartialWrites = 10000
+)
+
+// record is the representation of a received message written to a sink
+type record struct {
+	Subject          string    `json:"subject"`
+	StreamSequence   uint64    `json:"streamSequence"`
+	ConsumerSequence uint64    `json:"consumerSequence"`
+	Timestamp        time.Time `json:"timestamp"`
+	Received         time.Time `json:"received"`
+	Data             string    `json:"data"`
+}
+
+// sink is the output of the subscriber for received messages
+type sink interface {
+	Write(ctx context.Context, r record) error
+	Close() error
+}
+
+// newSink creates a sink writing to all of the given outputs
+func newSink(ctx context.Context, cfg config) (sink, error) {
+	var sinks []sink
+	for _, name := range cfg.Sinks {
+		var (
+			s   sink
+			err error
+		)
+
+		switch name {
+		case sinkLog:
+			s = logSink{logger: ctx.Value(loggerKey).(*zap.Logger)}
+		case sinkStdout:
+			s = &writerSink{w: os.Stdout}
+		case sinkFile:
+			s, err = newFileSink(cfg.SinkFileDir, cfg.SinkFileMaxBytes, cfg.SinkFileMaxBackups)
+		case sinkWebhook:
+			s, err = newWebhookSink(cfg.SinkWebhookURL, cfg.SinkWebhookTimeout)
+		default:
+			err = fmt.Errorf("unsupported sink %q", name)
+		}
+
+		if err != nil {
+			_ = newMultiSink(sinks...).Close()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if len(sinks) == 0 {
+		return nil, errors.New("no sink configured")
+	}
+
+	return newMultiSink(sinks...), nil
+}
+
+// multiSink writes records to all sinks. If a sink fails, the message is redelivered and only written to the sinks
+// which did not write it yet, identified by the stream sequence. Delivery is tracked in memory, so a message
+// redelivered to another replica or after a restart may still be written twice (at-least-once). Only the latest
+// maxPartial partially written records are tracked, older ones are written to all sinks again if they are redelivered.
+type multiSink struct {
+	sync.Mutex
+	sinks      []sink
+	maxPartial int
+	// partial holds the partially written records, oldest first
+	partial *list.List
+	// delivered holds the element of partial for each partially written record
+	delivered map[uint64]*list.Element
+}
+
+// partialWrite records which sinks wrote the record with the stream sequence
+type partialWrite struct {
+	sequence uint64
+	written  []bool
+}
+
+func newMultiSink(sinks ...sink) *multiSink {
+	return &multiSink{
+		sinks:      sinks,
+		maxPartial: maxPartialWrites,
+		partial:    list.New(),
+		delivered:  make(map[uint64]*list.Element),
+	}
+}
+
+func (m *multiSink) Write(ctx context.Context, r record) error {
+	var delivered []bool
+	m.Lock()
+	if e, ok := m.delivered[r.StreamSequence]; ok {
+		delivered = e.Value.(*partialWrite).written
+	}
+	m.Unlock()
+	if delivered == nil {
+		delivered = make([]bool, len(m.sinks))
+	}
+
+	var errs []error
+	for i, s := range m.sinks {
+		if delivered[i] {
+			continue
+		}
+		if err := s.Write(ctx, r); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered[i] = true
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.delivered[r.StreamSequence]
+	switch {
+	case len(errs) == 0 && ok:
+		m.forget(e)
+	case len(errs) > 0 && !ok:
+		m.delivered[r.StreamSequence] = m.partial.PushBack(&partialWrite{sequence: r.StreamSequence, written: delivered})
+		for m.partial.Len() > m.maxPartial {
+			m.forget(m.partial.Front())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forget stops tracking a partially written record, m must be locked
+func (m *multiSink) forget(e *list.Element) {
+	m.partial.Remove(e)
+	delete(m.delivered, e.Value.(*partialWrite).sequence)
+}
+
+func (m *multiSink) Close() error {
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// logSink writes records to the subscriber log
+type logSink struct {
+	logger *zap.Logger
+}
+
+func (s logSink) Write(_ context.Context, r record) error {
+	s.logger.Info(
+		"received nats message",
+		zap.String("data", r.Data),
+		zap.Uint64("streamSequence", r.StreamSequence),
+		zap.Uint64("consumerSequence", r.ConsumerSequence),
+	)
+	return nil
+}
+
+func (s logSink) Close() error {
+	return nil
+}
+
+// writerSink writes records as JSON lines to w
+type writerSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (s *writerSink) Write(_ context.Context, r record) error {
+	b, err := jsonLine(r)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err = s.w.Write(b)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink writes records as JSON lines to a file in dir. When the file exceeds maxBytes, it is rotated and up to
+// maxBackups rotated files are kept, e.g. messages.jsonl.1 being the most recent one.
+type fileSink struct {
+	sync.Mutex
+	dir        string
+	maxBytes   int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func newFileSink(dir string, maxBytes int64, maxBackups int) (*fileSink, error) {
+	if dir == "" {
+		return nil, errors.New("file sink requires a directory")
+	}
+
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("file sink max bytes must be positive: %d", maxBytes)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create file sink directory: %w", err)
+	}
+
+	s := fileSink{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *fileSink) Write(_ context.Context, r record) error {
+	b, err := jsonLine(r)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.f == nil {
+		return errors.New("file sink closed")
+	}
+
+	if s.size > 0 && s.size+int64(len(b)) > s.maxBytes {
+		if err = s.rotate(); err != nil {
+			return fmt.Errorf("rotate file: %w", err)
+		}
+	}
+
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path(0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("read file info: %w", err)
+	}
+
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path(0)); err != nil {
+			return err
+		}
+		return s.open()
+	}
+
+	if err := os.Remove(s.path(s.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for i := s.maxBackups - 1; i >= 0; i-- {
+		if err := os.Rename(s.path(i), s.path(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return s.open()
+}
+
+// path returns the path of the current (0) or a rotated file
+func (s *fileSink) path(backup int) string {
+	if backup == 0 {
+		return filepath.Join(s.dir, sinkFileName)
+	}
+	return filepath.Join(s.dir, fmt.Sprintf("%s.%d", sinkFileName, backup))
+}
+
+// webhookSink posts each record as JSON to an HTTP endpoint
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string, timeout time.Duration) (*webhookSink, error) {
+	if url == "" {
+		return nil, errors.New("webhook sink requires a url")
+	}
+
+	return &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *webhookSink) Write(ctx context.Context, r record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func jsonLine(r record) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("marshal record: %w", err)
+	}
+	return append(b, '\n'), nil
+}
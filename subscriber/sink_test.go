@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// recordingSink records written stream sequences and fails the first failures writes
+type recordingSink struct {
+	failures int
+	written  []uint64
+}
+
+func (s *recordingSink) Write(_ context.Context, r record) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink failed")
+	}
+	s.written = append(s.written, r.StreamSequence)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestMultiSinkRedelivery(t *testing.T) {
+	ok := &recordingSink{}
+	failing := &recordingSink{failures: 2}
+	m := newMultiSink(ok, failing)
+	ctx := context.Background()
+
+	// the message is redelivered until every sink wrote it
+	assert.ErrorContains(t, m.Write(ctx, record{StreamSequence: 1}), "sink failed")
+	assert.ErrorContains(t, m.Write(ctx, record{StreamSequence: 1}), "sink failed")
+	assert.NilError(t, m.Write(ctx, record{StreamSequence: 1}))
+	assert.NilError(t, m.Write(ctx, record{StreamSequence: 2}))
+
+	assert.DeepEqual(t, ok.written, []uint64{1, 2})
+	assert.DeepEqual(t, failing.written, []uint64{1, 2})
+	assert.Equal(t, len(m.delivered), 0)
+	assert.Equal(t, m.partial.Len(), 0)
+}
+
+func TestMultiSinkForgetsOldestPartialWrites(t *testing.T) {
+	ok := &recordingSink{}
+	failing := &recordingSink{failures: 3}
+	m := newMultiSink(ok, failing)
+	m.maxPartial = 2
+	ctx := context.Background()
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		assert.ErrorContains(t, m.Write(ctx, record{StreamSequence: seq}), "sink failed")
+	}
+	assert.Equal(t, len(m.delivered), 2)
+	assert.Equal(t, m.partial.Len(), 2)
+
+	// the oldest record is forgotten and written to all sinks again
+	for seq := uint64(1); seq <= 3; seq++ {
+		assert.NilError(t, m.Write(ctx, record{StreamSequence: seq}))
+	}
+
+	assert.DeepEqual(t, ok.written, []uint64{1, 2, 3, 1})
+	assert.DeepEqual(t, failing.written, []uint64{1, 2, 3})
+	assert.Equal(t, len(m.delivered), 0)
+	assert.Equal(t, m.partial.Len(), 0)
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	r := record{Subject: "e2e-topic", StreamSequence: 1, Data: "test message: 0"}
+	line, err := jsonLine(r)
+	assert.NilError(t, err)
+	size := int64(len(line))
+
+	tests := []struct {
+		name       string
+		maxBytes   int64
+		maxBackups int
+		records    int
+		// want is the number of records in the current file followed by the backups
+		want []int
+	}{
+		{name: "no rotation", maxBytes: 10 * size, maxBackups: 2, records: 3, want: []int{3}},
+		{name: "rotated", maxBytes: 2 * size, maxBackups: 2, records: 5, want: []int{1, 2, 2}},
+		{name: "oldest backups removed", maxBytes: size, maxBackups: 2, records: 5, want: []int{1, 1, 1}},
+		{name: "no backups", maxBytes: 2 * size, maxBackups: 0, records: 5, want: []int{1}},
+		{name: "record larger than max bytes", maxBytes: 1, maxBackups: 1, records: 2, want: []int{1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := newFileSink(dir, tt.maxBytes, tt.maxBackups)
+			assert.NilError(t, err)
+
+			for i := 0; i < tt.records; i++ {
+				r.StreamSequence = uint64(i + 1)
+				assert.NilError(t, s.Write(context.Background(), r))
+			}
+			assert.NilError(t, s.Close())
+			assert.ErrorContains(t, s.Write(context.Background(), r), "file sink closed")
+
+			// newest records are in the current file, the oldest in the last backup
+			next := uint64(tt.records)
+			for backup, n := range tt.want {
+				seqs := readRecords(t, s.path(backup))
+				assert.Equal(t, len(seqs), n, "records in %s", s.path(backup))
+				for i := len(seqs) - 1; i >= 0; i-- {
+					assert.Equal(t, seqs[i], next)
+					next--
+				}
+			}
+
+			_, err = os.Stat(s.path(len(tt.want)))
+			assert.Assert(t, os.IsNotExist(err), "unexpected file %s", s.path(len(tt.want)))
+		})
+	}
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	s, err := newFileSink(dir, 1024, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, s.Write(ctx, record{StreamSequence: 1}))
+	assert.NilError(t, s.Close())
+
+	// restart
+	s, err = newFileSink(dir, 1024, 1)
+	assert.NilError(t, err)
+	assert.NilError(t, s.Write(ctx, record{StreamSequence: 2}))
+	assert.NilError(t, s.Close())
+
+	assert.DeepEqual(t, readRecords(t, filepath.Join(dir, sinkFileName)), []uint64{1, 2})
+}
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		delay   time.Duration
+		wantErr string
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "unexpected status code 500"},
+		{name: "not modified", status: http.StatusNotModified, wantErr: "unexpected status code 304"},
+		{name: "timeout", status: http.StatusOK, delay: time.Second, wantErr: "send webhook request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received []record
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				assert.Check(t, req.Method == http.MethodPost)
+				assert.Check(t, req.Header.Get("Content-Type") == "application/json")
+
+				var r record
+				assert.Check(t, json.NewDecoder(req.Body).Decode(&r))
+
+				mu.Lock()
+				received = append(received, r)
+				mu.Unlock()
+
+				select {
+				case <-time.After(tt.delay):
+				case <-req.Context().Done():
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			s, err := newWebhookSink(srv.URL, 100*time.Millisecond)
+			assert.NilError(t, err)
+			defer s.Close()
+
+			r := record{Subject: "e2e-topic", StreamSequence: 7, Data: "test message: 6"}
+			err = s.Write(context.Background(), r)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, len(received), 1)
+			assert.Equal(t, received[0].StreamSequence, r.StreamSequence)
+			assert.Equal(t, received[0].Data, r.Data)
+		})
+	}
+}
+
+func TestWebhookSinkRequiresURL(t *testing.T) {
+	_, err := newWebhookSink("", time.Second)
+	assert.ErrorContains(t, err, "requires a url")
+}
+
+// readRecords returns the stream sequences of the records in the JSON lines file at path
+func readRecords(t *testing.T, path string) []uint64 {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	var seqs []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		assert.NilError(t, json.Unmarshal(scanner.Bytes(), &r), "line %q", strings.TrimSpace(scanner.Text()))
+		seqs = append(seqs, r.StreamSequence)
+	}
+	assert.NilError(t, scanner.Err())
+	return seqs
+}